@@ -0,0 +1,27 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+
+	"appengine"
+	"appengine/user"
+)
+
+// adminOnly replies 403 Forbidden to the requests of users other than the
+// administrators of the application, without calling h. Requests sent by
+// App Engine cron jobs are allowed too. backend.yaml restricts the same paths
+// with login: admin, this also covers the ones served under a prefix.
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := appengine.NewContext(r)
+		if !user.IsAdmin(c) && r.Header.Get("X-Appengine-Cron") != "true" {
+			writeError(w, http.StatusForbidden, "only the administrators can do that")
+			return
+		}
+		h(w, r)
+	}
+}
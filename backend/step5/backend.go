@@ -7,22 +7,70 @@
 package backend
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"appengine"
-	"appengine/memcache"
+	"appengine/delay"
 	"appengine/urlfetch"
 )
 
 func init() {
-	http.HandleFunc("/api/groups", getGroups)
+	ids = loadIDs()
+	meetupKeys = splitIDs(os.Getenv("MEETUP_API_KEYS"))
+	if key := os.Getenv("MEETUP_API_KEY"); len(meetupKeys) == 0 && key != "" {
+		meetupKeys = []string{key}
+	}
+	if u := os.Getenv("MEETUP_API_URL"); u != "" {
+		apiBaseURL = strings.TrimRight(u, "/")
+	}
+	if ua := os.Getenv("USER_AGENT"); ua != "" {
+		userAgent = ua
+	}
+	requestTimeout = durationEnv("REQUEST_TIMEOUT", requestTimeout)
+	fetchTimeout = durationEnv("FETCH_TIMEOUT", fetchTimeout)
+	workers = intEnv("FETCH_WORKERS", workers)
+	maxFetches = intEnv("MAX_FETCHES", maxFetches)
+	fetchSlots = make(chan struct{}, maxFetches)
+	sequentialFetch = os.Getenv("SEQUENTIAL_FETCH") == "1"
+	maxIDs = intEnv("MAX_IDS", maxIDs)
+	maxResponseSize = intEnv("MAX_RESPONSE_SIZE", maxResponseSize)
+	featuredIDs = dedup(splitIDs(os.Getenv("FEATURED_IDS")))
+	cacheTTL = durationEnv("CACHE_TTL", cacheTTL)
+	softTTL = durationEnv("SOFT_CACHE_TTL", softTTL)
+	partialRefresh = os.Getenv("PARTIAL_REFRESH") == "1"
+	notFoundTTL = durationEnv("NOT_FOUND_TTL", notFoundTTL)
+	if f, err := strconv.ParseFloat(os.Getenv("CACHE_JITTER"), 64); err == nil && f >= 0 && f < 1 {
+		cacheJitter = f
+	}
+	if v, ok := os.LookupEnv("TRACKING_PARAMS"); ok {
+		trackingParams = splitIDs(v)
+	}
+	handle("/api/groups", cors(getGroups))
+	handle("/api/group/", cors(getGroup))
+	handle("/api/groups/count", cors(countGroups))
+	handle("/api/countries", cors(getCountries))
 }
 
-var ids = []string{
+// defaultIDs are the meetup groups displayed when GROUP_IDS is not set.
+var defaultIDs = []string{
 	"golangsf",
 	"golangsv",
 	"golang-paris",
@@ -34,130 +82,2116 @@ var ids = []string{
 	"Go-User-Group-Hamburg",
 }
 
+var ids []string
+
+// loadIDs returns the group ids listed in the comma separated GROUP_IDS
+// environment variable without duplicates, or defaultIDs if it is not set.
+func loadIDs() []string {
+	v, ok := os.LookupEnv("GROUP_IDS")
+	if !ok {
+		return defaultIDs
+	}
+
+	return dedup(splitIDs(v))
+}
+
+// splitIDs returns the non empty ids in the comma separated list.
+func splitIDs(list string) []string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// maxIDs is the maximum number of ids a request can ask for, it can be
+// overridden with MAX_IDS.
+var maxIDs = 50
+
+// checkIDs returns the ids given in a request without duplicates, or an
+// error if any of them is not valid or there are too many.
+func checkIDs(ids []string) ([]string, error) {
+	ids = dedup(ids)
+	if len(ids) == 0 {
+		return nil, errors.New("no ids given")
+	}
+	if len(ids) > maxIDs {
+		return nil, fmt.Errorf("too many ids, the maximum is %d", maxIDs)
+	}
+	for _, id := range ids {
+		if !validID(id) {
+			return nil, fmt.Errorf("invalid id %q", id)
+		}
+	}
+	return ids, nil
+}
+
+// maxBodySize is the maximum size of the body of POST /api/groups.
+const maxBodySize = 64 << 10
+
+// maxResponseSize is the maximum size in bytes of a GET /api/groups
+// response, it can be overridden with MAX_RESPONSE_SIZE.
+var maxResponseSize = 8 << 20
+
+// decodeIDs decodes the ids in a request body like {"ids": ["golangsf"]}.
+func decodeIDs(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := dec.Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	return body.IDs, nil
+}
+
+// dedup returns the given ids removing any repeated ones, keeping the order.
+func dedup(ids []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// now, after and sleep are used instead of their time package counterparts
+// so tests can replace the clock, and random instead of rand.Float64.
+var (
+	now    = time.Now
+	after  = time.After
+	sleep  = time.Sleep
+	random = rand.Float64
+)
+
+// requestTimeout bounds how long getGroups waits for all the groups to be
+// fetched, it can be overridden with REQUEST_TIMEOUT.
+var requestTimeout = 10 * time.Second
+
+// durationEnv returns the duration in the given environment variable, or def
+// if it's not set or can't be parsed.
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// fetchTimeout is the deadline of every single request to meetup, it can be
+// overridden with FETCH_TIMEOUT.
+var fetchTimeout = 5 * time.Second
+
+// workers is the maximum number of groups fetched concurrently by a request,
+// it can be overridden with FETCH_WORKERS.
+var workers = 8
+
+// intEnv returns the positive integer in the given environment variable, or
+// def if it's not set or is not valid.
+func intEnv(name string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// meetupKeys are the meetup API keys, obtain yours from
+// https://secure.meetup.com/meetup_api/key/
+// A single key is read from MEETUP_API_KEY, several from MEETUP_API_KEYS.
+var meetupKeys []string
+
+// nextKey is incremented on every request to rotate through meetupKeys.
+var nextKey uint32
+
+// keyDisabledFor is how long a key rejected by meetup is skipped for.
+const keyDisabledFor = 10 * time.Minute
+
+// disabledKeys holds until when every rejected key is skipped.
+var disabledKeys = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+var (
+	errNoAPIKey = errors.New("meetup API key not configured")
+	ErrNotFound = statusError(http.StatusNotFound) // the group doesn't exist
+	errNoIDs    = errors.New("no group ids configured")
+
+	// errNotModified is returned by conditional fetches when the group
+	// didn't change.
+	errNotModified = statusError(http.StatusNotModified)
+)
+
+// The kinds of errors fetching groups, other than ErrNotFound and
+// ErrRateLimited: meetup could not be reached, it replied with an error, or
+// we didn't even ask since there were too many requests to meetup in progress.
+// Kind returns the kind of an error.
+var (
+	ErrNetwork   = errors.New("network error")
+	ErrMeetupAPI = errors.New("meetup API error")
+	ErrBusy      = errors.New("too busy")
+)
+
+// kindError is an error of the given kind.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+// Kind returns the kind of an error fetching a group: ErrNotFound,
+// ErrRateLimited, ErrNetwork, ErrMeetupAPI or ErrBusy, or nil if it's none of
+// them.
+func Kind(err error) error {
+	switch e := err.(type) {
+	case *kindError:
+		return e.kind
+	case *apiError:
+		return ErrMeetupAPI
+	case statusError:
+		if e == ErrNotFound {
+			return ErrNotFound
+		}
+		return ErrMeetupAPI
+	}
+	if err == ErrRateLimited {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// kindNames are the names of the error kinds used in the responses.
+var kindNames = map[error]string{
+	ErrNotFound:    "not_found",
+	ErrRateLimited: "rate_limited",
+	ErrNetwork:     "network",
+	ErrMeetupAPI:   "meetup",
+	ErrBusy:        "busy",
+}
+
+// fetchError is the JSON object reporting an error loading a group.
+type fetchError struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// newFetchError returns the fetchError for err, which happened loading the
+// group with the given id.
+func newFetchError(id string, err error) fetchError {
+	kind, ok := kindNames[Kind(err)]
+	switch {
+	case err == errTimeout:
+		kind = "timeout"
+	case !ok:
+		kind = "internal"
+	}
+	return fetchError{id, kind, err.Error()}
+}
+
+// sortErrors sorts the errors by group id and kind, so they don't depend on
+// the order of the fetches, removing the repeated ones. The result is never
+// nil, so it's encoded as an empty list when there are no errors.
+func sortErrors(errs []fetchError) []fetchError {
+	sort.Slice(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Message < b.Message
+	})
+	unique := []fetchError{}
+	for i, e := range errs {
+		if i == 0 || e != errs[i-1] {
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// statusError is returned by fetch when meetup replies with a non 2xx status.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", int(e))
+}
+
+// hasAPIKey reports whether a meetup API key is configured, without moving
+// the rotation of apiKey forward.
+func hasAPIKey() bool {
+	return len(meetupKeys) > 0
+}
+
+// apiKey returns the meetup API key to use for the next request, rotating
+// through the configured keys and skipping the disabled ones. If they're all
+// disabled it returns one anyway.
+func apiKey() (string, error) {
+	switch len(meetupKeys) {
+	case 0:
+		return "", errNoAPIKey
+	case 1:
+		return meetupKeys[0], nil
+	}
+
+	n := int(atomic.AddUint32(&nextKey, 1))
+	disabledKeys.Lock()
+	defer disabledKeys.Unlock()
+	for i := range meetupKeys {
+		key := meetupKeys[(n+i)%len(meetupKeys)]
+		if now().After(disabledKeys.until[key]) {
+			return key, nil
+		}
+	}
+	return meetupKeys[n%len(meetupKeys)], nil
+}
+
+// keyRejected reports whether a response with the given error status and
+// message means meetup rejected our API key: a 401, or a 403 saying the key is
+// invalid. Other 403s are for groups the key is not allowed to see.
+func keyRejected(code int, msg string) bool {
+	if code == http.StatusUnauthorized {
+		return true
+	}
+	msg = strings.ToLower(msg)
+	return code == http.StatusForbidden && strings.Contains(msg, "key") && strings.Contains(msg, "invalid")
+}
+
+// disableKey stops using the given key for keyDisabledFor, unless it's the
+// only one.
+func disableKey(c appengine.Context, key string) {
+	if len(meetupKeys) < 2 {
+		return
+	}
+	for i, k := range meetupKeys {
+		if k == key {
+			c.Warningf("meetup API key %d rejected, disabled for %v", i, keyDisabledFor)
+		}
+	}
+	disabledKeys.Lock()
+	disabledKeys.until[key] = now().Add(keyDisabledFor)
+	disabledKeys.Unlock()
+}
+
 type Group struct {
-	Name    string
-	URL     string
-	Members int
-	City    string
-	Country string
+	ID          string
+	Name        string
+	URL         string
+	PhotoURL    string `json:",omitempty"`
+	Organizer   string `json:",omitempty"` // the organizer's name
+	Members     int
+	City        string
+	Country     string
+	CountryCode string // upper case ISO 3166 code
+	CountryName string // empty for unknown countries
+	Lat         float64
+	Lon         float64
+	Fetched     time.Time // when the data was fetched from meetup
+	Events      *[]Event  `json:",omitempty"` // with ?events=1, empty if they failed to load
+	Stale       bool      `json:",omitempty"` // served because fetching failed
+
+	// NextEventName and NextEventTime summarize the next event of the
+	// group, they're left out when there's none.
+	NextEventName string     `json:",omitempty"`
+	NextEventTime *time.Time `json:",omitempty"`
+
+	// GrowthLast30d is how many members the group gained in the last 30
+	// days, set with ?growth=1 when there's history, see history.go.
+	GrowthLast30d *int `json:",omitempty"`
+}
+
+// groupsParams are the query parameters accepted by getGroups. jQuery adds _
+// to the JSONP requests so they're not cached.
+var groupsParams = []string{
+	"_",
+	"callback",
+	"country",
+	"debug",
+	"errors",
+	"events",
+	"features",
+	"fields",
+	"format",
+	"ids",
+	"limit",
+	"minMembers",
+	"nocache",
+	"offset",
+	"pretty",
+	"sequential",
+	"sort",
+	"stream",
+}
+
+// apiVersion is the version of the API responses, reported in every one of
+// them. Bump it when their shape changes in incompatible ways.
+const apiVersion = "2"
+
+// groupsResponse is the response of getGroups, encoded as JSON by groupsJSON.
+type groupsResponse struct {
+	APIVersion   string `json:"apiVersion"`
+	Groups       []*Group
+	Errors       []fetchError // listed even if empty, unless ?errors=omit
+	TotalMembers int
+	Stats        *Stats            `json:",omitempty"`
+	Page         *Page             `json:",omitempty"`
+	Debug        map[string]string `json:",omitempty"` // group id to cache or network
+}
+
+// groupsJSON is the JSON encoding of a groupsResponse. Its Errors field hides
+// the one of the response, so they can be left out with ?errors=omit while an
+// empty list is still encoded otherwise.
+type groupsJSON struct {
+	*groupsResponse
+	Errors *[]fetchError `json:",omitempty"`
+}
+
+// newGroupsJSON returns the groupsJSON of res, without the errors if omit is
+// set.
+func newGroupsJSON(res *groupsResponse, omit bool) groupsJSON {
+	if omit {
+		return groupsJSON{res, nil}
+	}
+	return groupsJSON{res, &res.Errors}
+}
+
+// Stats summarizes the member counts of the groups in a response.
+type Stats struct {
+	Total    int
+	Average  float64
+	Min      int
+	Max      int
+	MaxGroup string // id of the group with Max members
+}
+
+// groupStats returns the stats of the given groups, or nil if there are none.
+func groupStats(groups []*Group) *Stats {
+	if len(groups) == 0 {
+		return nil
+	}
+	s := &Stats{Min: groups[0].Members, Max: groups[0].Members, MaxGroup: groups[0].ID}
+	for _, g := range groups {
+		s.Total += g.Members
+		if g.Members < s.Min {
+			s.Min = g.Members
+		}
+		if g.Members > s.Max {
+			s.Max, s.MaxGroup = g.Members, g.ID
+		}
+	}
+	s.Average = float64(s.Total) / float64(len(groups))
+	return s
+}
+
+// maxLimit is the maximum number of groups per page.
+const maxLimit = 1000
+
+// Page describes the window of groups returned when paginating.
+type Page struct {
+	Total  int // number of groups in all the pages
+	Limit  int
+	Offset int
+}
+
+// parsePage returns the page given by the limit and offset parameters.
+func parsePage(r *http.Request) (*Page, error) {
+	p := &Page{Limit: maxLimit}
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxLimit {
+			return nil, fmt.Errorf("limit must be between 0 and %d", maxLimit)
+		}
+		p.Limit = n
+	}
+	if v := r.FormValue("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.New("offset must not be negative")
+		}
+		p.Offset = n
+	}
+	return p, nil
+}
+
+// slice returns the groups in the page.
+func (p *Page) slice(groups []*Group) []*Group {
+	if p.Offset >= len(groups) {
+		return []*Group{}
+	}
+	groups = groups[p.Offset:]
+	if p.Limit < len(groups) {
+		groups = groups[:p.Limit]
+	}
+	return groups
 }
 
 func getGroups(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := newContext(w, r)
+	start := now()
 
-	var res struct {
-		Groups []*Group
-		Errors []string
+	if unknown := unknownParams(r, groupsParams); len(unknown) > 0 {
+		writeError(w, http.StatusBadRequest, "unknown parameters: "+strings.Join(unknown, ", "))
+		return
 	}
 
-	type partial struct {
-		id    string
-		group *Group
-		err   error
+	// the format can depend on the Accept header
+	if format := r.FormValue("format"); format == "" {
+		w.Header().Add("Vary", "Accept")
+	} else if !validFormat(format) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid format %q, want json, csv, html or geojson", format))
+		return
 	}
 
-	partials := make(chan partial)
+	// identical requests get the same response for a few seconds, unless
+	// they ask to skip the cache
+	nocache := r.FormValue("nocache")
+	key := responseKey(r)
+	if cached, ok := loadResponse(key); ok && nocache == "" {
+		for h, v := range cached.header {
+			w.Header()[h] = v
+		}
+		// this time every group comes from the cache
+		hits, _ := strconv.Atoi(cached.header.Get("X-Cache-Hits"))
+		misses, _ := strconv.Atoi(cached.header.Get("X-Cache-Misses"))
+		w.Header().Set("X-Cache-Hits", strconv.Itoa(hits+misses))
+		w.Header().Set("X-Cache-Misses", "0")
+		setResponseTime(w, start)
+		writeCacheable(c, w, r, cached.body)
+		return
+	}
 
-	// let's fetch every group concurrently
-	for _, id := range ids {
-		go func(id string) {
-			group, err := load(c, id)
-			partials <- partial{id, group, err}
-		}(id)
+	if !hasAPIKey() {
+		writeError(w, http.StatusInternalServerError, errNoAPIKey.Error())
+		c.Errorf("get groups: %v", errNoAPIKey)
+		return
+	}
+	// the configured ids can be overridden in the request
+	ids, custom := ids, false
+	if r.Method == "POST" {
+		list, err := decodeIDs(w, r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if ids, err = checkIDs(list); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		custom = true
+	} else if list := r.FormValue("ids"); list != "" {
+		var err error
+		if ids, err = checkIDs(splitIDs(list)); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		custom = true
+	}
+	if len(ids) == 0 {
+		writeError(w, http.StatusInternalServerError, errNoIDs.Error())
+		c.Errorf("get groups: %v", errNoIDs)
+		return
 	}
 
-	// and get the results when they're ready
-	for _ = range ids {
-		p := <-partials
-		if p.err != nil {
-			res.Errors = append(res.Errors, fmt.Sprintf("fetch %v: %v", p.id, p.err))
-			continue
+	opts, err := parseFeatures(r, groupsFeatures)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cache, err := requestCache(c, nocache)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	callback := r.FormValue("callback")
+	if callback != "" && !validCallback(callback) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid callback %q", callback))
+		return
+	}
+
+	var fields []string
+	if list := r.FormValue("fields"); list != "" {
+		var err error
+		if fields, err = parseFields(list); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-		res.Groups = append(res.Groups, p.group)
 	}
 
-	// then we encode it as JSON on the response
-	enc := json.NewEncoder(w)
-	err := enc.Encode(res)
+	errorsMode := r.FormValue("errors")
+	if errorsMode != "" && errorsMode != "omit" && errorsMode != "only" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid errors %q, want omit or only", errorsMode))
+		return
+	}
+
+	minMembers := 0
+	if v := r.FormValue("minMembers"); v != "" {
+		var err error
+		if minMembers, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid minMembers %q", v))
+			return
+		}
+	}
+
+	var page *Page
+	if r.FormValue("limit") != "" || r.FormValue("offset") != "" {
+		var err error
+		if page, err = parsePage(r); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	res := groupsResponse{APIVersion: apiVersion}
+
+	// let's fetch every group concurrently, workers stop picking up ids once
+	// the request is canceled, which also happens when the handler returns.
+	done := r.Context().Done()
+
+	events := opts.Events
+
+	// unless they're fetched one at a time, in order, which is easier to
+	// follow in the logs
+	sequential := sequentialFetch || opts.Sequential
+	fetch := fetchAll
+	if sequential {
+		fetch = fetchSequential
+	}
+
+	// in stream mode every result is written as soon as it's ready
+	if opts.Stream {
+		streamGroups(c, w, ids, fetch(c, cache, ids, events, done), done)
+		return
+	}
+
+	// otherwise we use the cached list of groups, or collect them all
+	hits := 0
+	sources := make(map[string]string)
+	var groups []*Group
+	allCached := false
+	if !events && !custom && !sequential && nocache == "" {
+		groups, allCached = loadAll(c, ids)
+	}
+	if allCached {
+		res.Groups = groups
+		hits = len(groups)
+		for _, g := range groups {
+			sources[g.ID] = "cache"
+		}
+	} else {
+		ok := collect(c, ids, fetch(c, cache, ids, events, done), done, func(p partial) {
+			if p.err != nil {
+				res.Errors = append(res.Errors, newFetchError(p.id, p.err))
+				return
+			}
+			if p.eventsErr != nil {
+				e := newFetchError(p.id, p.eventsErr)
+				e.Message = "events: " + e.Message
+				res.Errors = append(res.Errors, e)
+			}
+			sources[p.id] = "network"
+			if p.cached {
+				hits++
+				sources[p.id] = "cache"
+			}
+			res.Groups = append(res.Groups, p.group)
+		})
+		if !ok {
+			return
+		}
+
+		// only complete lists of the configured groups are cached
+		if len(res.Errors) == 0 && !events && !custom && !sequential && nocache != "full" {
+			storeAll(c, ids, res.Groups)
+		}
+	}
+	res.Errors = sortErrors(res.Errors)
+	logInfo(c, "get groups", "groups", len(res.Groups), "cached", hits,
+		"errors", len(res.Errors), "ms", millis(now().Sub(start)))
+	w.Header().Set("X-Cache-Hits", strconv.Itoa(hits))
+	w.Header().Set("X-Cache-Misses", strconv.Itoa(len(res.Groups)-hits))
+	if opts.Debug {
+		res.Debug = sources
+	}
+
+	// if every fetch failed this is not a partial success
+	status := http.StatusOK
+	if len(res.Groups) == 0 && len(res.Errors) > 0 {
+		status = http.StatusBadGateway
+	}
+
+	// keep only the groups in the requested country, if any
+	if country := r.FormValue("country"); country != "" {
+		res.Groups = filterCountry(res.Groups, country)
+	}
+	// and with enough members
+	if minMembers > 0 {
+		res.Groups = filterMembers(res.Groups, minMembers)
+	}
+
+	// and sort them so the response doesn't depend on the fetch order, with
+	// the featured ones first. Sequential fetches keep the order of the ids
+	// unless a sort order is requested.
+	if order := r.FormValue("sort"); !sequential || order != "" {
+		sortGroups(res.Groups, order)
+	}
+	res.Groups = featureGroups(res.Groups)
 
-	// And if encoding fails we log the error
+	for _, g := range res.Groups {
+		res.TotalMembers += g.Members
+	}
+	res.Stats = groupStats(res.Groups)
+
+	// and return only the requested page, if any
+	if page != nil {
+		page.Total = len(res.Groups)
+		res.Groups = page.slice(res.Groups)
+		res.Page = page
+	}
+
+	// the errors are always listed, even if there are none, unless they're
+	// left out with ?errors=omit. With ?errors=only they're all we list.
+	switch {
+	case errorsMode == "omit":
+		res.Errors = nil
+	case res.Errors == nil:
+		res.Errors = []fetchError{}
+	}
+	if errorsMode == "only" {
+		res.Groups = nil
+	}
+
+	// then we encode it in the requested format, JSON by default
+	buf := &bytes.Buffer{}
+	switch responseFormat(r) {
+	case "csv":
+		// CSV has no place for the errors so we just report how many
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("X-Fetch-Errors", strconv.Itoa(len(res.Errors)))
+		err = encodeCSV(buf, res.Groups)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = encodeHTML(buf, &res)
+	case "geojson":
+		w.Header().Set("Content-Type", "application/geo+json")
+		var missing []string
+		missing, err = encodeGeoJSON(buf, res.Groups)
+		if len(missing) > 0 {
+			w.Header().Set("X-Missing-Coordinates", strings.Join(missing, ","))
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		js := newGroupsJSON(&res, errorsMode == "omit")
+		var v interface{} = js
+		if fields != nil {
+			v = projectGroups(js, fields)
+		}
+		if errorsMode == "only" {
+			v = struct {
+				APIVersion string `json:"apiVersion"`
+				Errors     []fetchError
+			}{res.APIVersion, res.Errors}
+		}
+		err = newEncoder(buf, r).Encode(v)
+
+		// JSONP clients get it wrapped in a call to their callback
+		if callback != "" {
+			w.Header().Set("Content-Type", "application/javascript")
+			buf = wrapJSONP(callback, buf.Bytes())
+		}
+	}
+
+	// And if encoding fails we log the error, nothing has been written yet
+	// so the client gets a clean error
 	if err != nil {
 		c.Errorf("encode response: %v", err)
+		w.Header().Del("X-Fetch-Errors")
+		w.Header().Del("X-Missing-Coordinates")
+		writeError(w, http.StatusInternalServerError, "internal encoding error")
+		return
+	}
+
+	// a response that big needs to be asked for in pages
+	if buf.Len() > maxResponseSize {
+		c.Warningf("get groups: response of %d bytes is over the %d limit", buf.Len(), maxResponseSize)
+		w.Header().Del("X-Fetch-Errors")
+		w.Header().Del("X-Missing-Coordinates")
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("the response is over %d bytes, ask for fewer groups with limit and offset", maxResponseSize))
+		return
 	}
+
+	// otherwise we write it with its caching headers
+	setResponseTime(w, start)
+	if status == http.StatusOK {
+		if nocache == "" {
+			storeResponse(key, w.Header(), buf.Bytes())
+		}
+		writeCacheable(c, w, r, buf.Bytes())
+		return
+	}
+	writeBody(c, w, r, status, buf.Bytes())
 }
 
-func load(c appengine.Context, id string) (*Group, error) {
-	group := &Group{}
-	_, err := memcache.JSON.Get(c, id, group)
-	if err == nil {
-		return group, nil
+// cacheTTL is how long fetched groups are cached, both in memcache and by
+// the clients. It can be overridden with CACHE_TTL, zero or negative values
+// disable caching.
+var cacheTTL = time.Hour
+
+// writeCacheable writes the body with an ETag and Cache-Control headers, or
+// replies 304 Not Modified if the request's If-None-Match matches the ETag.
+func writeCacheable(c appengine.Context, w http.ResponseWriter, r *http.Request, body []byte) {
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	w.Header().Set("ETag", etag)
+	if cacheTTL > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cacheTTL.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
 	}
-	if err != memcache.ErrCacheMiss {
-		c.Errorf("memcache get %q: %v", id, err)
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if match = strings.TrimSpace(match); match == etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 	}
+	writeBody(c, w, r, http.StatusOK, body)
+}
 
-	group, err = fetch(c, id)
-	if err != nil {
-		return nil, err
+// setResponseTime sets the X-Response-Time header to the time elapsed since
+// start, when the request was received.
+func setResponseTime(w http.ResponseWriter, start time.Time) {
+	w.Header().Set("X-Response-Time", fmt.Sprintf("%dms", millis(now().Sub(start))))
+}
+
+// writeBody writes the status and body to the response, compressing the body
+// with gzip if the client accepts it.
+func writeBody(c appengine.Context, w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.WriteHeader(status)
+		w.Write(body)
+		return
 	}
 
-	item := &memcache.Item{
-		Key:        id,
-		Object:     group,
-		Expiration: time.Hour,
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(body); err != nil {
+		c.Errorf("gzip response: %v", err)
 	}
-	err = memcache.JSON.Set(c, item)
-	if err != nil {
-		c.Errorf("memcache set %q: %v", id, err)
+	if err := gz.Close(); err != nil {
+		c.Errorf("gzip close: %v", err)
 	}
-	return group, nil
 }
 
-// fetch fetches a meetup group given its id from using the meetup API
-// docs for the API: http://www.meetup.com/meetup_api/docs/
-func fetch(c appengine.Context, id string) (*Group, error) {
-	const (
-		apiKey      = "obtain your apikey from https://secure.meetup.com/meetup_api/key/"
-		urlTemplate = "https://api.meetup.com/%s?sign=true&key=%s"
-	)
+// partial is the result of loading a single group.
+type partial struct {
+	id        string
+	group     *Group
+	cached    bool
+	err       error
+	eventsErr error
+}
 
-	client := urlfetch.Client(c)
-	res, err := client.Get(fmt.Sprintf(urlTemplate, id, apiKey))
-	if err != nil {
-		return nil, fmt.Errorf("get: %v", err)
+var errTimeout = errors.New("timeout")
+
+// fetchAll loads the groups with the given ids concurrently, using a bounded
+// pool of workers, and sends the results on the returned channel as soon as
+// they're ready, caching them in cache. If events is true the events of every
+// group are loaded too. Workers stop picking up new ids once done is closed.
+func fetchAll(c appengine.Context, cache Cache, ids []string, events bool, done <-chan struct{}) <-chan partial {
+	return fetchWorkers(c, cache, ids, events, workers, done)
+}
+
+// sequentialFetch makes getGroups always fetch the groups one at a time, set
+// SEQUENTIAL_FETCH=1 to enable it.
+var sequentialFetch bool
+
+// fetchSequential is like fetchAll, but loads the groups one at a time in the
+// given order.
+func fetchSequential(c appengine.Context, cache Cache, ids []string, events bool, done <-chan struct{}) <-chan partial {
+	return fetchWorkers(c, cache, ids, events, 1, done)
+}
+
+// fetchWorkers loads the groups with the given ids using n workers.
+func fetchWorkers(c appengine.Context, cache Cache, ids []string, events bool, n int, done <-chan struct{}) <-chan partial {
+	// the channel is buffered so late fetches don't block forever once we
+	// stop waiting for them.
+	partials := make(chan partial, len(ids))
+
+	work := make(chan string, len(ids))
+	for _, id := range ids {
+		work <- id
 	}
+	close(work)
 
-	var g struct {
-		Name    string `json:"name"`
-		Link    string `json:"link"`
-		City    string `json:"city"`
-		Country string `json:"country"`
-		Members int    `json:"members"`
-		Errors  []struct {
-			Message string `json:"message"`
-		} `json:"errors"`
+	// the workers can still be loading groups after the request is done,
+	// so Shutdown waits for them
+	for i := 0; i < n && i < len(ids); i++ {
+		inBackground(func() {
+			for id := range work {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				partials <- loadPartial(c, cache, id, events)
+			}
+		})
+	}
+	return partials
+}
+
+// loadPartial loads the group with the given id and, if events is true, its
+// events concurrently. Failing to load the events is not fatal.
+func loadPartial(c appengine.Context, cache Cache, id string, events bool) partial {
+	if !events {
+		group, cached, err := load(c, cache, fetcher, id)
+		return partial{id: id, group: group, cached: cached, err: err}
 	}
 
-	dec := json.NewDecoder(res.Body)
-	err = dec.Decode(&g)
-	if err != nil {
-		return nil, fmt.Errorf("decode: %v", err)
+	var evs []Event
+	var evErr error
+	evDone := make(chan bool)
+	go func() {
+		evs, evErr = loadEvents(c, id)
+		close(evDone)
+	}()
+
+	group, cached, err := load(c, cache, fetcher, id)
+	<-evDone
+	p := partial{id: id, group: group, cached: cached, err: err, eventsErr: evErr}
+	if group != nil {
+		// copy the group so the events don't end up in other caches
+		g := *group
+		if evs == nil {
+			evs = []Event{}
+		}
+		g.Events = &evs
+		p.group = &g
+	}
+	return p
+}
+
+// collect calls emit with the results sent by fetchAll for the given ids as
+// they arrive. If requestTimeout passes before all of them are received, emit
+// is called for the missing ones with errTimeout. Results for ids that were
+// already received, or never asked for, are dropped. collect returns false if
+// done is closed before it's finished.
+func collect(c appengine.Context, ids []string, partials <-chan partial, done <-chan struct{}, emit func(partial)) bool {
+	pending := make(map[string]bool)
+	for _, id := range ids {
+		pending[id] = true
+	}
+
+	timeout := after(requestTimeout)
+wait:
+	for len(pending) > 0 {
+		select {
+		case p := <-partials:
+			if !pending[p.id] {
+				c.Errorf("collect: dropped unexpected result for %q", p.id)
+				continue
+			}
+			delete(pending, p.id)
+			emit(p)
+		case <-timeout:
+			break wait
+		case <-done:
+			c.Warningf("request canceled, abandoned %d fetches", len(pending))
+			return false
+		}
 	}
 
-	if len(g.Errors) > 0 {
-		var errs []string
-		for _, e := range g.Errors {
-			errs = append(errs, e.Message)
+	// the groups we were still waiting for are reported as timeouts
+	for _, id := range ids {
+		if pending[id] {
+			atomic.AddInt64(&metrics.Timeouts, 1)
+			emit(partial{id: id, err: errTimeout})
+		}
+	}
+	return true
+}
+
+// streamGroups writes the groups as they're loaded using newline delimited
+// JSON: every line holds either a Group, or an object with a single "error"
+// field for the groups that couldn't be loaded.
+func streamGroups(c appengine.Context, w http.ResponseWriter, ids []string, partials <-chan partial, done <-chan struct{}) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	// once writing fails, most likely because the client is gone, we stop
+	// waiting for the rest of the groups. The workers stop too once we
+	// return, since the request is done then.
+	stop := make(chan struct{})
+	var once sync.Once
+	quit := func() { once.Do(func() { close(stop) }) }
+	go func() {
+		select {
+		case <-done:
+			quit()
+		case <-stop:
+		}
+	}()
+
+	sent := 0
+	var writeErr error
+	collect(c, ids, partials, stop, func(p partial) {
+		if writeErr != nil {
+			return
+		}
+		var err error
+		if p.err != nil {
+			err = enc.Encode(errorResponse{fmt.Sprintf("fetch %v: %v", p.id, p.err)})
+		} else {
+			if p.eventsErr != nil {
+				err = enc.Encode(errorResponse{fmt.Sprintf("fetch %v events: %v", p.id, p.eventsErr)})
+			}
+			if err == nil {
+				err = enc.Encode(p.group)
+			}
+		}
+		if err != nil {
+			writeErr = err
+			quit()
+			return
 		}
-		return nil, fmt.Errorf(strings.Join(errs, "\n"))
+		sent++
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	if writeErr != nil {
+		c.Warningf("stream groups: %v, %d of %d groups unsent", writeErr, len(ids)-sent, len(ids))
+	}
+}
+
+// getGroup replies with the group whose id is given in the request path, as in
+// /api/group/golangsf.
+func getGroup(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/group/")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing group id")
+		return
+	}
+	if !validID(id) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid id %q", id))
+		return
+	}
+
+	if !hasAPIKey() {
+		writeError(w, http.StatusInternalServerError, errNoAPIKey.Error())
+		c.Errorf("get group: %v", errNoAPIKey)
+		return
 	}
 
-	return &Group{
-		Name:    g.Name,
-		URL:     g.Link,
-		Members: g.Members,
-		City:    g.City,
-		Country: g.Country,
-	}, nil
+	opts, err := parseFeatures(r, groupFeatures)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cache, err := requestCache(c, r.FormValue("nocache"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	group, _, err := load(c, cache, fetcher, id)
+	if err == ErrNotFound {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("group %q not found", id))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("fetch %v: %v", id, err))
+		return
+	}
+
+	if opts.Growth {
+		g := *group
+		g.GrowthLast30d, err = growth(c, &g, 30)
+		if err != nil {
+			c.Errorf("growth %v: %v", id, err)
+		}
+		group = &g
+	}
+
+	buf := &bytes.Buffer{}
+	if err := newEncoder(buf, r).Encode(group); err != nil {
+		c.Errorf("encode response: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal encoding error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeCacheable(c, w, r, buf.Bytes())
+}
+
+// countGroups replies with the number of configured groups and how many of
+// them can be loaded, or only the former if ?cheap=1 is given.
+func countGroups(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	if r.FormValue("cheap") == "1" {
+		writeJSON(c, w, r, struct {
+			APIVersion string `json:"apiVersion"`
+			Configured int    `json:"configured"`
+		}{apiVersion, len(ids)})
+		return
+	}
+
+	if !hasAPIKey() {
+		writeError(w, http.StatusInternalServerError, errNoAPIKey.Error())
+		c.Errorf("count groups: %v", errNoAPIKey)
+		return
+	}
+
+	var res struct {
+		APIVersion string `json:"apiVersion"`
+		Configured int    `json:"configured"`
+		Available  int    `json:"available"`
+		Errors     int    `json:"errors"`
+	}
+	res.APIVersion = apiVersion
+	res.Configured = len(ids)
+
+	done := r.Context().Done()
+	ok := collect(c, ids, fetchAll(c, newCache(c), ids, false, done), done, func(p partial) {
+		if p.err != nil {
+			res.Errors++
+			return
+		}
+		res.Available++
+	})
+	if ok {
+		writeJSON(c, w, r, res)
+	}
+}
+
+// countryCount is the number of groups in a country.
+type countryCount struct {
+	Country string `json:"country"`
+	Count   int    `json:"count"`
+}
+
+// getCountries replies with the number of groups in every country, the
+// countries with more groups first.
+func getCountries(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	if !hasAPIKey() {
+		writeError(w, http.StatusInternalServerError, errNoAPIKey.Error())
+		c.Errorf("get countries: %v", errNoAPIKey)
+		return
+	}
+
+	res := struct {
+		APIVersion string         `json:"apiVersion"`
+		Countries  []countryCount `json:"countries"`
+		Errors     []fetchError   `json:"errors"`
+	}{APIVersion: apiVersion, Countries: []countryCount{}}
+
+	counts := make(map[string]int)
+	done := r.Context().Done()
+	ok := collect(c, ids, fetchAll(c, newCache(c), ids, false, done), done, func(p partial) {
+		if p.err != nil {
+			res.Errors = append(res.Errors, newFetchError(p.id, p.err))
+			return
+		}
+		counts[strings.ToLower(p.group.Country)]++
+	})
+	if !ok {
+		return
+	}
+	res.Errors = sortErrors(res.Errors)
+
+	for country, n := range counts {
+		res.Countries = append(res.Countries, countryCount{country, n})
+	}
+	sort.Slice(res.Countries, func(i, j int) bool {
+		a, b := res.Countries[i], res.Countries[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Country < b.Country
+	})
+	writeJSON(c, w, r, res)
+}
+
+// filterCountry returns the groups in the given country, ignoring case.
+func filterCountry(groups []*Group, country string) []*Group {
+	var filtered []*Group
+	for _, g := range groups {
+		if strings.EqualFold(g.Country, country) {
+			filtered = append(filtered, g)
+		}
+	}
+	return filtered
+}
+
+// filterMembers returns the groups with at least min members.
+func filterMembers(groups []*Group, min int) []*Group {
+	var filtered []*Group
+	for _, g := range groups {
+		if g.Members >= min {
+			filtered = append(filtered, g)
+		}
+	}
+	return filtered
+}
+
+// sortGroups sorts the groups by the given key: name or members, with a -
+// prefix for descending order. Unknown keys sort by ascending name.
+func sortGroups(groups []*Group, key string) {
+	byName := func(i, j int) bool { return groups[i].Name < groups[j].Name }
+	less := byName
+	switch key {
+	case "-name":
+		less = func(i, j int) bool { return groups[i].Name > groups[j].Name }
+	case "members":
+		less = func(i, j int) bool {
+			if groups[i].Members != groups[j].Members {
+				return groups[i].Members < groups[j].Members
+			}
+			return byName(i, j)
+		}
+	case "-members":
+		less = func(i, j int) bool {
+			if groups[i].Members != groups[j].Members {
+				return groups[i].Members > groups[j].Members
+			}
+			return byName(i, j)
+		}
+	}
+	sort.Slice(groups, less)
+}
+
+// unknownParams returns the sorted names of the query parameters in the
+// request that are not in allowed.
+func unknownParams(r *http.Request, allowed []string) []string {
+	var unknown []string
+	for name := range r.URL.Query() {
+		known := false
+		for _, a := range allowed {
+			if name == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// writeJSON writes v to the response encoded as JSON.
+func writeJSON(c appengine.Context, w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := newEncoder(w, r).Encode(v); err != nil {
+		c.Errorf("encode response: %v", err)
+	}
+}
+
+// newEncoder returns a JSON encoder writing to w, which indents the output
+// when the request has pretty=1, or pretty in its features.
+func newEncoder(w io.Writer, r *http.Request) *json.Encoder {
+	enc := json.NewEncoder(w)
+	// the handlers that care report unknown features before encoding
+	if opts, _ := parseFeatures(r, featureNames); opts.Pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc
+}
+
+// errorResponse is the JSON object sent to report errors.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// featuredIDs are the ids of the groups always listed first, in this order.
+// They're read from the comma separated FEATURED_IDS.
+var featuredIDs []string
+
+// featureGroups returns the groups with the featured ones moved to the front,
+// keeping the order of the rest.
+func featureGroups(groups []*Group) []*Group {
+	if len(featuredIDs) == 0 {
+		return groups
+	}
+
+	byID := make(map[string]*Group)
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+
+	sorted := make([]*Group, 0, len(groups))
+	featured := make(map[string]bool)
+	for _, id := range featuredIDs {
+		if g, ok := byID[id]; ok {
+			sorted = append(sorted, g)
+			featured[id] = true
+		}
+	}
+	for _, g := range groups {
+		if !featured[g.ID] {
+			sorted = append(sorted, g)
+		}
+	}
+	return sorted
+}
+
+// writeError replies to the request with the given HTTP code and a JSON
+// object containing the error message.
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorResponse{msg})
+}
+
+// A Fetcher fetches the information of a meetup group given its id.
+type Fetcher interface {
+	Fetch(c appengine.Context, id string) (*Group, error)
+}
+
+// A conditionalFetcher is a Fetcher that can also fetch a group only if it
+// was modified after the given time, returning errNotModified otherwise.
+type conditionalFetcher interface {
+	Fetcher
+	FetchIfModified(c appengine.Context, id string, since time.Time) (*Group, error)
+}
+
+// A memberFetcher is a Fetcher that can also fetch only the member count of
+// a group, which changes more often than the rest.
+type memberFetcher interface {
+	Fetcher
+	FetchMembers(c appengine.Context, id string) (int, error)
+}
+
+// meetupFetcher is the Fetcher backed by the meetup API.
+type meetupFetcher struct{}
+
+func (meetupFetcher) Fetch(c appengine.Context, id string) (*Group, error) {
+	return fetch(c, id, time.Time{})
+}
+
+func (meetupFetcher) FetchIfModified(c appengine.Context, id string, since time.Time) (*Group, error) {
+	return fetch(c, id, since)
+}
+
+func (meetupFetcher) FetchMembers(c appengine.Context, id string) (int, error) {
+	return fetchMembers(c, id)
+}
+
+// fetcher is the Fetcher used by the handlers.
+var fetcher Fetcher = meetupFetcher{}
+
+// load returns the group with the given id from the cache, using f to fetch
+// it when it's not been cached yet. cached reports whether the cache had it.
+func load(c appengine.Context, cache Cache, f Fetcher, id string) (group *Group, cached bool, err error) {
+	start := now()
+	defer func() {
+		source := "network"
+		if cached {
+			source = "cache"
+		}
+		logInfo(c, "fetch", "id", id, "source", source, "ms", millis(now().Sub(start)))
+	}()
+
+	if cacheTTL <= 0 {
+		group, err = fetchOnce(c, f, id, nil)
+		return group, false, err
+	}
+
+	var cg cachedGroup
+	err = cache.Get(id, &cg)
+	if err == nil && cg.Missing {
+		atomic.AddInt64(&metrics.CacheHits, 1)
+		return nil, true, ErrNotFound
+	}
+	if err == nil && cg.Group != nil {
+		atomic.AddInt64(&metrics.CacheHits, 1)
+		// stale groups are still served, but refreshed in a task, a
+		// single one until it's done
+		if softTTL > 0 && now().After(cg.SoftExpiry) && claimRefresh(c, cache, id) {
+			queueRefresh(c, id)
+		}
+		return cg.Group, true, nil
+	}
+	atomic.AddInt64(&metrics.CacheMisses, 1)
+	if err != nil && err != ErrCacheMiss {
+		c.Errorf("cache get %q: %v", id, err)
+	}
+
+	// with the last copy we fetched meetup can tell us nothing changed
+	last, _ := loadLastGood(c, cache, id)
+	group, err = fetchOnce(c, f, id, last)
+	if err == errNotModified && last != nil {
+		group = last
+		group.Stale = false
+		group.Fetched = now().UTC().Truncate(time.Second)
+		err = nil
+	}
+	if err != nil {
+		// unless the group is gone, the last copy we fetched is better
+		// than nothing
+		if err != ErrNotFound && last != nil {
+			c.Warningf("fetch %v: %v, serving a stale copy", id, err)
+			return last, true, nil
+		}
+		// and if memcache lost it too, this instance may still have it
+		if err != ErrNotFound {
+			if g, ok := fallback.get(id); ok {
+				c.Warningf("fetch %v: %v, serving a stale copy from memory", id, err)
+				return g, true, nil
+			}
+		}
+		if err == ErrNotFound {
+			storeMissing(c, cache, id)
+		}
+		return nil, false, err
+	}
+	store(c, cache, id, group)
+	saveHistory(c, group)
+	return group, false, nil
+}
+
+// softTTL is how long a cached group is fresh for. After that, and until
+// cacheTTL expires it, load serves the stale group while it's refreshed in a
+// task. Set SOFT_CACHE_TTL to enable it.
+var softTTL time.Duration
+
+// notFoundTTL is how long we remember that a group doesn't exist on meetup,
+// it can be overridden with NOT_FOUND_TTL.
+var notFoundTTL = 5 * time.Minute
+
+// cachedGroup is the object cached for each group. Missing is set
+// instead of Group for the groups meetup doesn't know about. Expiry is when
+// the cache expires the group.
+type cachedGroup struct {
+	Group      *Group `json:",omitempty"`
+	SoftExpiry time.Time
+	Expiry     time.Time
+	Missing    bool `json:",omitempty"`
+}
+
+// storeMissing caches that the group with the given id doesn't exist, so we
+// don't ask meetup again for a while.
+func storeMissing(c appengine.Context, cache Cache, id string) {
+	if notFoundTTL <= 0 {
+		return
+	}
+	if err := cache.Set(id, cachedGroup{Missing: true}, jitter(notFoundTTL)); err != nil {
+		c.Errorf("cache set %q: %v", id, err)
+	}
+}
+
+// cacheJitter is the fraction of the TTL by which the cached groups expire
+// earlier or later, so they don't all expire at the same time. It can be
+// overridden with CACHE_JITTER.
+var cacheJitter = 0.1
+
+// jitter returns the given TTL randomly moved by up to cacheJitter of it.
+func jitter(ttl time.Duration) time.Duration {
+	return ttl + time.Duration(float64(ttl)*cacheJitter*(2*random()-1))
+}
+
+// store caches the group with the given id, and keeps it as the last known
+// good copy of the group, in the cache and in fallback.
+func store(c appengine.Context, cache Cache, id string, group *Group) {
+	storeUntil(c, cache, id, group, now().Add(jitter(cacheTTL)))
+}
+
+// storeUntil is like store, but the cached group expires at the given time.
+func storeUntil(c appengine.Context, cache Cache, id string, group *Group, expiry time.Time) {
+	fallback.add(group)
+	cg := cachedGroup{Group: group, SoftExpiry: now().Add(softTTL), Expiry: expiry}
+	err := cache.SetMulti([]CacheItem{
+		{id, cg, expiry.Sub(now())},
+		{lastGoodKey(id), group, lastGoodTTL},
+	})
+	if err != nil {
+		c.Errorf("cache set %q: %v", id, err)
+	}
+}
+
+// lastGoodTTL is how long the last successfully fetched copy of a group is
+// kept, to be served when meetup is not available.
+const lastGoodTTL = 7 * 24 * time.Hour
+
+func lastGoodKey(id string) string { return "last-good:" + id }
+
+// loadLastGood returns the last known good copy of the group with the given
+// id, marked as stale.
+func loadLastGood(c appengine.Context, cache Cache, id string) (*Group, bool) {
+	key := lastGoodKey(id)
+	group := &Group{}
+	if err := cache.Get(key, group); err != nil {
+		if err != ErrCacheMiss {
+			c.Errorf("cache get %q: %v", key, err)
+		}
+		return nil, false
+	}
+	group.Stale = true
+	return group, true
+}
+
+// refreshLater refreshes the cached group with the given id in a task queue
+// task, since the request that found it stale may be done before the refresh.
+var refreshLater = delay.Func("refresh", func(c appengine.Context, id string) {
+	cache := newCache(c)
+	var cg cachedGroup
+	if err := cache.Get(id, &cg); err != nil && err != ErrCacheMiss {
+		c.Errorf("cache get %q: %v", id, err)
+	}
+	refresh(c, cache, fetcher, id, &cg)
+})
+
+// refreshingTTL is how long a refresh claimed with claimRefresh keeps the
+// others from being queued, unless it updates the group earlier.
+const refreshingTTL = time.Minute
+
+func refreshingKey(id string) string { return "refreshing:" + id }
+
+// claimRefresh reports whether the refresh of the stale group with the given
+// id should be queued, which is not the case if another request queued it
+// already. When the cache fails the refresh is queued anyway.
+func claimRefresh(c appengine.Context, cache Cache, id string) bool {
+	key := refreshingKey(id)
+	err := cache.Add(key, true, refreshingTTL)
+	if err == ErrNotStored {
+		return false
+	}
+	if err != nil {
+		c.Errorf("cache add %q: %v", key, err)
+	}
+	return true
+}
+
+// queueRefresh queues the refresh of the cached group with the given id, tests
+// replace it to refresh the group right away.
+var queueRefresh = func(c appengine.Context, id string) { refreshLater.Call(c, id) }
+
+// refresh fetches the group with the given id and updates the cache with it.
+// With partialRefresh only the member count of the cached copy is updated,
+// when f can fetch it, see refreshMembers.
+func refresh(c appengine.Context, cache Cache, f Fetcher, id string, cached *cachedGroup) {
+	if partialRefresh && refreshMembers(c, cache, f, id, cached) {
+		doneRefreshing(c, cache, id)
+		return
+	}
+
+	group, err := fetchOnce(c, f, id, nil)
+	if err != nil {
+		c.Errorf("refresh %v: %v", id, err)
+		return
+	}
+	store(c, cache, id, group)
+	saveHistory(c, group)
+	doneRefreshing(c, cache, id)
+}
+
+// doneRefreshing lets the next load finding the group stale queue a refresh
+// again. After a failed refresh that waits for refreshingTTL, so an outage of
+// meetup doesn't queue one per request.
+func doneRefreshing(c appengine.Context, cache Cache, id string) {
+	key := refreshingKey(id)
+	if err := cache.Delete(key); err != nil && err != ErrCacheMiss && err != errCacheUnavailable {
+		c.Errorf("cache delete %q: %v", key, err)
+	}
+}
+
+// refreshMembers fetches the member count of the group with the given id and
+// updates the cached copy with it. The copy keeps its expiry, so the whole
+// group is fetched again once cacheTTL has passed, and its Fetched time, so
+// the conditional fetches don't miss the changes to the rest of it. It
+// returns false if the group has to be fetched instead.
+func refreshMembers(c appengine.Context, cache Cache, f Fetcher, id string, cached *cachedGroup) bool {
+	mf, ok := f.(memberFetcher)
+	if !ok || cached == nil || cached.Group == nil || cached.Expiry.Sub(now()) < time.Second {
+		return false
+	}
+	members, err := fetchMembersOnce(c, mf, id)
+	if err != nil {
+		c.Warningf("refresh members %v: %v, fetching the whole group", id, err)
+		return false
+	}
+	g := *cached.Group
+	g.Members = members
+	g.Stale = false
+	storeUntil(c, cache, id, &g, cached.Expiry)
+	saveHistory(c, &g)
+	return true
+}
+
+// partialRefresh makes the refreshes of stale cached groups fetch only their
+// member count, which changes more often than the rest. Set PARTIAL_REFRESH=1
+// to enable it.
+var partialRefresh bool
+
+// fetchMembers fetches only the member count of the group with the given id,
+// using meetup's only parameter.
+func fetchMembers(c appengine.Context, id string) (int, error) {
+	if !validID(id) {
+		return 0, fmt.Errorf("invalid id %q", id)
+	}
+
+	res, err := meetupGet(c, groupPath(id), url.Values{"only": {"members"}}, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, &kindError{ErrNetwork, fmt.Errorf("read: %v", err)}
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return 0, &kindError{ErrMeetupAPI, fmt.Errorf("decode %v: %v (body: %q)", id, err, snippet(body))}
+	}
+	if msg := meetupErrors(body); msg != "" {
+		return 0, &kindError{ErrMeetupAPI, errors.New(msg)}
+	}
+	m, err = unwrapResults(m)
+	if err == ErrNotFound {
+		return 0, err
+	}
+	if err != nil {
+		return 0, &kindError{ErrMeetupAPI, fmt.Errorf("decode %v: %v (body: %q)", id, err, snippet(body))}
+	}
+	var members *flexInt
+	if err := decodeField(m, "Members", &members); err != nil {
+		return 0, &kindError{ErrMeetupAPI, fmt.Errorf("decode: %v", err)}
+	}
+	if members == nil {
+		return 0, &kindError{ErrMeetupAPI, errors.New("no member count")}
+	}
+	return int(*members), nil
+}
+
+// inflight holds the fetches in progress by inflightKey, so concurrent loads
+// of the same group wait for a single fetch instead of calling meetup again.
+var inflight = struct {
+	sync.Mutex
+	calls map[string]*fetchCall
+}{calls: make(map[string]*fetchCall)}
+
+// fetchCall is a fetch in progress, or finished once done is closed.
+// members is set instead of group by the fetches of the member count.
+type fetchCall struct {
+	done    chan struct{}
+	group   *Group
+	members int
+	err     error
+}
+
+// inflightKey returns the key of a fetch in inflight. Conditional fetches are
+// kept apart since they can return errNotModified, which is of no use to the
+// loads without a previous copy of the group.
+func inflightKey(id string, last *Group) string {
+	if last != nil {
+		return id + "|conditional"
+	}
+	return id
+}
+
+// fetchOnce fetches the group with the given id using f, unless it's being
+// fetched already, in which case it waits for that fetch and returns its
+// result. See fetchGroup for the meaning of last.
+func fetchOnce(c appengine.Context, f Fetcher, id string, last *Group) (*Group, error) {
+	call := fetchShared(inflightKey(id, last), func(call *fetchCall) {
+		call.group, call.err = fetchGroup(c, f, id, last)
+	})
+	return call.group, call.err
+}
+
+// fetchMembersOnce is like fetchOnce, but fetches only the member count of
+// the group.
+func fetchMembersOnce(c appengine.Context, f memberFetcher, id string) (int, error) {
+	call := fetchShared(id+"|members", func(call *fetchCall) {
+		call.members, call.err = fetchGroupMembers(c, f, id)
+	})
+	return call.members, call.err
+}
+
+// fetchShared makes the fetch with the given key in inflight, unless it's in
+// progress already, and returns the finished call.
+func fetchShared(key string, fetch func(call *fetchCall)) *fetchCall {
+	inflight.Lock()
+	if call, ok := inflight.calls[key]; ok {
+		inflight.Unlock()
+		<-call.done
+		return call
+	}
+	call := &fetchCall{done: make(chan struct{})}
+	inflight.calls[key] = call
+	inflight.Unlock()
+
+	fetch(call)
+
+	inflight.Lock()
+	delete(inflight.calls, key)
+	inflight.Unlock()
+	close(call.done)
+	return call
+}
+
+// validIDRE matches the meetup group ids we accept.
+var validIDRE = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// validID reports whether id looks like a meetup group id, so it can be safely
+// used in the meetup API urls.
+func validID(id string) bool {
+	return validIDRE.MatchString(id)
+}
+
+// millis returns the duration as a whole number of milliseconds.
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// fetch fetches a meetup group given its id from using the meetup API
+// docs for the API: http://www.meetup.com/meetup_api/docs/
+// If since is not zero and the group wasn't modified after it, fetch returns
+// errNotModified.
+func fetch(c appengine.Context, id string, since time.Time) (*Group, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("invalid id %q", id)
+	}
+
+	header := http.Header{}
+	if !since.IsZero() {
+		header.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	}
+	res, err := meetupGet(c, groupPath(id), nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &kindError{ErrNetwork, fmt.Errorf("read: %v", err)}
+	}
+	// meetup sometimes replies with an HTML page or a truncated body, the
+	// beginning of the body tells what happened
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, &kindError{ErrMeetupAPI, fmt.Errorf("decode %v: %v (body: %q)", id, err, snippet(body))}
+	}
+
+	// meetup can report errors even in 200 responses
+	if msg := meetupErrors(body); msg != "" {
+		return nil, &kindError{ErrMeetupAPI, errors.New(msg)}
+	}
+
+	// the fields are found by the names in fieldNames, in the group or in
+	// the results of the legacy API
+	m, err = unwrapResults(m)
+	if err == ErrNotFound {
+		return nil, err
+	}
+	if err == nil && !hasFields(m) {
+		err = errors.New("no group fields")
+	}
+	if err != nil {
+		return nil, &kindError{ErrMeetupAPI, fmt.Errorf("decode %v: %v (body: %q)", id, err, snippet(body))}
+	}
+	var g struct {
+		Name      string
+		Link      string
+		Photo     string
+		Organizer string
+		NextName  string
+		NextTime  int64 // milliseconds since the epoch
+		City      string
+		Country   string
+		Members   flexInt
+		Lat       float64
+		Lon       float64
+	}
+	for _, f := range []struct {
+		field string
+		v     interface{}
+	}{
+		{"Name", &g.Name},
+		{"URL", &g.Link},
+		{"PhotoURL", &g.Photo},
+		{"Organizer", &g.Organizer},
+		{"NextEventName", &g.NextName},
+		{"NextEventTime", &g.NextTime},
+		{"City", &g.City},
+		{"Country", &g.Country},
+		{"Members", &g.Members},
+		{"Lat", &g.Lat},
+		{"Lon", &g.Lon},
+	} {
+		if err := decodeField(m, f.field, f.v); err != nil {
+			return nil, &kindError{ErrMeetupAPI, fmt.Errorf("decode: %v", err)}
+		}
+	}
+
+	group := &Group{
+		ID:            id,
+		Name:          g.Name,
+		URL:           normalizeURL(c, g.Link),
+		PhotoURL:      g.Photo,
+		Organizer:     g.Organizer,
+		NextEventName: g.NextName,
+		Members:       int(g.Members),
+		City:          g.City,
+		Country:       g.Country,
+		CountryCode:   strings.ToUpper(g.Country),
+		CountryName:   countryNames[strings.ToUpper(g.Country)],
+		Lat:           g.Lat,
+		Lon:           g.Lon,
+		Fetched:       now().UTC().Truncate(time.Second),
+	}
+	if g.NextTime != 0 {
+		t := time.Unix(0, g.NextTime*int64(time.Millisecond)).UTC()
+		group.NextEventTime = &t
+	}
+	return group, nil
+
+}
+
+// trackingParams are the query parameters removed from the group urls, they
+// can be overridden with TRACKING_PARAMS.
+var trackingParams = []string{
+	"utm_source",
+	"utm_medium",
+	"utm_campaign",
+	"utm_term",
+	"utm_content",
+	"_af",
+	"_af_eid",
+	"_xtd",
+}
+
+// normalizeURL returns the given group url using https and without tracking
+// parameters. Urls that can't be parsed are returned unchanged.
+func normalizeURL(c appengine.Context, link string) string {
+	if link == "" {
+		return ""
+	}
+	if !strings.Contains(link, "://") {
+		link = "https://" + strings.TrimPrefix(link, "//")
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		c.Warningf("invalid group url %q", link)
+		return link
+	}
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for _, p := range trackingParams {
+			q.Del(p)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// apiBaseURL is the base url of the meetup API, it can be overridden with
+// MEETUP_API_URL to use a proxy or a test server.
+var apiBaseURL = "https://api.meetup.com"
+
+// userAgent identifies us in the requests to meetup, it can be overridden with
+// USER_AGENT.
+var userAgent = "golang-groups/1.0"
+
+// meetupGet sends a signed GET request to the meetup API for the given path,
+// query parameters and headers. Non 2xx responses are returned as a
+// statusError or an apiError, otherwise the caller must close the response
+// body.
+func meetupGet(c appengine.Context, path string, query url.Values, header http.Header) (*http.Response, error) {
+	key, err := apiKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	// the path can have its own query parameters
+	if i := strings.Index(path, "?"); i >= 0 {
+		q, err := url.ParseQuery(path[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", path, err)
+		}
+		for k, v := range q {
+			query[k] = v
+		}
+		path = path[:i]
+	}
+	query.Set("sign", "true")
+	query.Set("key", key)
+	u := apiBaseURL + "/" + path + "?" + query.Encode()
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	client := &http.Client{Transport: &urlfetch.Transport{
+		Context:  c,
+		Deadline: fetchTimeout,
+	}}
+	res, err := getWithRetry(client, req, requestDeadline(c))
+	if err == ErrRateLimited || err == errBusy {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &kindError{ErrNetwork, fmt.Errorf("get: %v", err)}
+	}
+	if res == nil {
+		return nil, &kindError{ErrNetwork, errors.New("get: no response")}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		code := res.StatusCode
+		if code == http.StatusNotModified || code == http.StatusNotFound {
+			io.Copy(ioutil.Discard, res.Body)
+			return nil, statusError(code)
+		}
+
+		// try to find out what went wrong from the body
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64<<10))
+		io.Copy(ioutil.Discard, res.Body)
+		msg := meetupErrors(body)
+		if msg == "" {
+			msg = fmt.Sprintf("body: %q", snippet(body))
+		}
+		if keyRejected(code, msg) {
+			disableKey(c, key)
+		}
+		return nil, &apiError{code, msg}
+	}
+	return res, nil
+}
+
+// apiError is returned by meetupGet when meetup replies with an error status,
+// other than the ones reported with a statusError.
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.code, e.msg)
+}
+
+// snippetLen is the maximum length of the body snippets in errors.
+const snippetLen = 128
+
+// snippet returns the beginning of the body, to be included in errors.
+func snippet(body []byte) string {
+	if len(body) > snippetLen {
+		return string(body[:snippetLen]) + "..."
+	}
+	return string(body)
+}
+
+// meetupErrors returns the error messages found in a meetup API response
+// body, which can be reported as:
+//
+//	{"errors": [{"code": "...", "message": "..."}]}
+//	{"errors": {"code": "...", "message": "..."}}
+//	{"error": "...", "error_description": "..."}
+//	{"problem": "...", "details": "..."}
+//
+// It returns an empty string if there are none.
+func meetupErrors(body []byte) string {
+	var v struct {
+		Errors           json.RawMessage `json:"errors"`
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+		Problem          string          `json:"problem"`
+		Details          string          `json:"details"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return ""
+	}
+
+	type message struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	text := func(m message) string {
+		if m.Message == "" {
+			return m.Code
+		}
+		return m.Message
+	}
+
+	var msgs []string
+	if len(v.Errors) > 0 {
+		var list []message
+		var one message
+		if err := json.Unmarshal(v.Errors, &list); err == nil {
+			for _, m := range list {
+				msgs = append(msgs, text(m))
+			}
+		} else if err := json.Unmarshal(v.Errors, &one); err == nil {
+			msgs = append(msgs, text(one))
+		}
+	}
+	if len(v.Error) > 0 {
+		var s string
+		var one message
+		if err := json.Unmarshal(v.Error, &s); err == nil {
+			msgs = append(msgs, s)
+		} else if err := json.Unmarshal(v.Error, &one); err == nil {
+			msgs = append(msgs, text(one))
+		}
+	}
+	if v.ErrorDescription != "" {
+		msgs = append(msgs, v.ErrorDescription)
+	}
+	if v.Problem != "" {
+		msgs = append(msgs, v.Problem)
+	}
+	if v.Details != "" {
+		msgs = append(msgs, v.Details)
+	}
+	return strings.Join(msgs, ": ")
+}
+
+// retries is the maximum number of times a failed request to meetup is
+// retried, waiting retryDelay before the first retry and doubling it after.
+var (
+	retries    = 3
+	retryDelay = 100 * time.Millisecond
+)
+
+// ErrRateLimited is returned when meetup rejects a request because we went
+// over the API quota.
+var ErrRateLimited = errors.New("meetup API rate limit exceeded")
+
+// maxFetches is the maximum number of requests to meetup in progress at any
+// time by all the requests of the instance, it can be overridden with
+// MAX_FETCHES.
+var maxFetches = 20
+
+// fetchSlots holds a value for every request to meetup in progress.
+var fetchSlots chan struct{}
+
+// errBusy is returned when there are maxFetches requests to meetup in progress
+// until the deadline of the request.
+var errBusy = &kindError{ErrBusy, errors.New("too many requests to meetup in progress")}
+
+// acquireSlot waits for a free slot in fetchSlots until the deadline, and
+// returns the function releasing it, or errBusy if there's none by then.
+func acquireSlot(deadline time.Time) (release func(), err error) {
+	release = func() { <-fetchSlots }
+	select {
+	case fetchSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	timer := time.NewTimer(deadline.Sub(now()))
+	defer timer.Stop()
+	select {
+	case fetchSlots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, errBusy
+	}
+}
+
+// getWithRetry sends the given GET request, retrying with exponential backoff
+// on network errors and server errors as long as the deadline allows it.
+// Rate limited requests are retried once after the delay asked by meetup.
+// Every attempt waits for a slot in fetchSlots, which is not held while
+// waiting to retry.
+func getWithRetry(client *http.Client, req *http.Request, deadline time.Time) (*http.Response, error) {
+	delay := retryDelay
+	rateRetried := false
+	for i := 0; ; i++ {
+		release, err := acquireSlot(deadline)
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req)
+		release()
+		if err == nil && res.StatusCode == http.StatusTooManyRequests {
+			atomic.AddInt64(&metrics.RateLimited, 1)
+			res.Body.Close()
+			wait, ok := retryAfter(res)
+			if rateRetried || !ok || now().Add(wait).After(deadline) {
+				return nil, ErrRateLimited
+			}
+			rateRetried = true
+			sleep(wait)
+			continue
+		}
+		if err == nil && res.StatusCode < 500 {
+			return res, nil
+		}
+		if i == retries || now().Add(delay).After(deadline) {
+			return res, err
+		}
+		if err == nil {
+			res.Body.Close()
+		}
+		sleep(delay)
+		delay *= 2
+	}
+}
+
+// retryAfter returns the delay given in the Retry-After header of res, if
+// any, expressed in seconds.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	secs, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// flexInt is an int that can be decoded from either a JSON number or a JSON
+// string containing a number, as meetup sometimes sends member counts.
+type flexInt int
+
+// flexInt satisfies json.Unmarshaler.
+func (n *flexInt) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", b)
+	}
+	*n = flexInt(v)
+	return nil
 }
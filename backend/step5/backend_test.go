@@ -0,0 +1,2831 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"appengine"
+	"appengine/aetest"
+)
+
+// inst is the App Engine instance the requests of the tests are made to.
+var inst aetest.Instance
+
+func TestMain(m *testing.M) {
+	var err error
+	inst, err = aetest.NewInstance(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "start instance: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	inst.Close()
+	os.Exit(code)
+}
+
+// newRequest returns a request to the test instance.
+func newRequest(t testing.TB, method, url string, body io.Reader) *http.Request {
+	r, err := inst.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	return r
+}
+
+// newTestContext returns a context for a request to the test instance.
+func newTestContext(t testing.TB) appengine.Context {
+	return appengine.NewContext(newRequest(t, "GET", "/", nil))
+}
+
+// serve calls h with the request and returns the recorded response.
+func serve(h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// get calls h with a GET request for the given url.
+func get(t *testing.T, h http.HandlerFunc, url string) *httptest.ResponseRecorder {
+	return serve(h, newRequest(t, "GET", url, nil))
+}
+
+// decode decodes the JSON body of the response into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+// setKeys sets the meetup API keys, and returns a function restoring the
+// previous ones.
+func setKeys(keys ...string) func() {
+	old := meetupKeys
+	meetupKeys = keys
+	disabledKeys.Lock()
+	disabledKeys.until = make(map[string]time.Time)
+	disabledKeys.Unlock()
+	return func() { meetupKeys = old }
+}
+
+// stubFetcher is a Fetcher serving copies of the given groups, or the given
+// errors, and counting the fetches of every group. Unknown groups are not
+// found. If wait is not nil the fetches wait for it to be closed.
+type stubFetcher struct {
+	mu     sync.Mutex
+	groups map[string]*Group
+	errs   map[string]error
+	calls  map[string]int
+	wait   chan struct{}
+}
+
+func newStubFetcher(groups ...*Group) *stubFetcher {
+	f := &stubFetcher{
+		groups: make(map[string]*Group),
+		errs:   make(map[string]error),
+		calls:  make(map[string]int),
+	}
+	for _, g := range groups {
+		f.groups[g.ID] = g
+	}
+	return f
+}
+
+func (f *stubFetcher) Fetch(c appengine.Context, id string) (*Group, error) {
+	if f.wait != nil {
+		<-f.wait
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[id]++
+	if err, ok := f.errs[id]; ok {
+		return nil, err
+	}
+	g, ok := f.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copy := *g
+	return &copy, nil
+}
+
+// fail makes the fetches of the group with the given id return err.
+func (f *stubFetcher) fail(id string, err error) {
+	f.mu.Lock()
+	f.errs[id] = err
+	f.mu.Unlock()
+}
+
+// fetches returns how many times the group with the given id was fetched.
+func (f *stubFetcher) fetches(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[id]
+}
+
+// testGroups returns the groups used by most tests.
+func testGroups() []*Group {
+	return []*Group{
+		{ID: "golangsf", Name: "GoSF", Members: 100, City: "San Francisco", Country: "us", CountryCode: "US"},
+		{ID: "golang-paris", Name: "Golang Paris", Members: 50, City: "Paris", Country: "fr", CountryCode: "FR"},
+		{ID: "golang-users-berlin", Name: "Go Users Berlin", Members: 80, City: "Berlin", Country: "de", CountryCode: "DE"},
+	}
+}
+
+// setup makes the handlers serve the given groups, in this order, using a
+// stubFetcher and an empty MemoryCache. It returns a function restoring the
+// previous state, to be deferred.
+func setup(groups ...*Group) (*stubFetcher, *MemoryCache, func()) {
+	oldIDs, oldFetcher, oldCache := ids, fetcher, newCache
+	oldCacheTTL, oldSoftTTL, oldResponseTTL := cacheTTL, softTTL, responseTTL
+	oldFallback, oldFeatured, oldRandom := fallback, featuredIDs, random
+	restoreKeys := setKeys("test-key")
+
+	f := newStubFetcher(groups...)
+	cache := NewMemoryCache()
+	ids = nil
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	fetcher = f
+	newCache = func(appengine.Context) Cache { return cache }
+	cacheTTL, softTTL = time.Hour, 0
+	fallback, featuredIDs = newGroupLRU(0), nil
+	random = func() float64 { return 0.5 } // no jitter
+	flushResponses()
+	breaker.Lock()
+	breaker.failures, breaker.openUntil = 0, time.Time{}
+	breaker.Unlock()
+
+	return f, cache, func() {
+		ids, fetcher, newCache = oldIDs, oldFetcher, oldCache
+		cacheTTL, softTTL, responseTTL = oldCacheTTL, oldSoftTTL, oldResponseTTL
+		fallback, featuredIDs, random = oldFallback, oldFeatured, oldRandom
+		restoreKeys()
+		flushResponses()
+	}
+}
+
+// groupIDs returns the ids of the groups.
+func groupIDs(groups []*Group) []string {
+	ids := []string{}
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	return ids
+}
+
+// setEnv sets the environment variable, or unsets it if value is nil, and
+// returns a function restoring its previous value.
+func setEnv(name string, value *string) func() {
+	old, ok := os.LookupEnv(name)
+	if value == nil {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, *value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func str(s string) *string { return &s }
+
+func TestLoadIDs(t *testing.T) {
+	tests := []struct {
+		env  *string
+		want []string
+	}{
+		{nil, defaultIDs},
+		{str(""), nil},
+		{str("golangsf"), []string{"golangsf"}},
+		{str(" golangsf , golang-paris,"), []string{"golangsf", "golang-paris"}},
+		{str(",,golangsf,,"), []string{"golangsf"}},
+	}
+	for _, tt := range tests {
+		restore := setEnv("GROUP_IDS", tt.env)
+		got := loadIDs()
+		restore()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GROUP_IDS=%v: loadIDs() = %q, want %q", envString(tt.env), got, tt.want)
+		}
+	}
+}
+
+// envString returns the value of an environment variable for the test
+// errors, quoted unless it's unset.
+func envString(v *string) string {
+	if v == nil {
+		return "<unset>"
+	}
+	return strconv.Quote(*v)
+}
+
+func TestAPIKey(t *testing.T) {
+	defer setKeys()()
+	if _, err := apiKey(); err != errNoAPIKey {
+		t.Errorf("apiKey() with no keys: got error %v, want %v", err, errNoAPIKey)
+	}
+
+	setKeys("secret")
+	if key, err := apiKey(); key != "secret" || err != nil {
+		t.Errorf("apiKey() = %q, %v; want %q, nil", key, err, "secret")
+	}
+}
+
+func TestHasAPIKey(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	setKeys()
+	if hasAPIKey() {
+		t.Error("hasAPIKey() with no keys = true, want false")
+	}
+
+	// checking the keys doesn't move their rotation forward
+	setKeys("a", "b")
+	n := atomic.LoadUint32(&nextKey)
+	if !hasAPIKey() {
+		t.Error("hasAPIKey() with two keys = false, want true")
+	}
+	get(t, getGroups, "/api/groups")
+	get(t, getGroup, "/api/group/golangsf")
+	if got := atomic.LoadUint32(&nextKey); got != n {
+		t.Errorf("the key rotation moved from %d to %d without calling meetup", n, got)
+	}
+}
+
+func TestGetGroupsNoAPIKey(t *testing.T) {
+	defer setKeys()()
+	flushResponses()
+
+	w := get(t, getGroups, "/api/groups")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	var res errorResponse
+	decode(t, w, &res)
+	if want := "meetup API key not configured"; res.Error != want {
+		t.Errorf("got error %q, want %q", res.Error, want)
+	}
+}
+
+func TestCollectTimeout(t *testing.T) {
+	timeout := make(chan time.Time, 1)
+	defer func(old func(time.Duration) <-chan time.Time) { after = old }(after)
+	after = func(time.Duration) <-chan time.Time { return timeout }
+
+	// the timeout fires once the first group is received
+	partials := make(chan partial, 1)
+	partials <- partial{id: "golangsf", group: &Group{ID: "golangsf"}}
+	var got []partial
+	ok := collect(newTestContext(t), []string{"golangsf", "golang-paris"}, partials, nil, func(p partial) {
+		got = append(got, p)
+		timeout <- time.Time{}
+	})
+	if !ok {
+		t.Fatal("collect returned false, want true")
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d results, want 2", len(got))
+	}
+	if got[0].id != "golangsf" || got[0].err != nil {
+		t.Errorf("first result: got %q, %v; want %q, nil", got[0].id, got[0].err, "golangsf")
+	}
+	if got[1].id != "golang-paris" || got[1].err != errTimeout {
+		t.Errorf("second result: got %q, %v; want %q, %v", got[1].id, got[1].err, "golang-paris", errTimeout)
+	}
+	want := fetchError{"golang-paris", "timeout", "timeout"}
+	if e := newFetchError(got[1].id, got[1].err); e != want {
+		t.Errorf("newFetchError = %+v, want %+v", e, want)
+	}
+}
+
+func TestCollectDuplicates(t *testing.T) {
+	timeout := make(chan time.Time, 1)
+	defer func(old func(time.Duration) <-chan time.Time) { after = old }(after)
+	after = func(time.Duration) <-chan time.Time { return timeout }
+
+	// a misbehaving fetch sends golangsf twice, a group never asked for, and
+	// nothing for golang-users-berlin
+	partials := make(chan partial, 4)
+	partials <- partial{id: "golangsf", group: &Group{ID: "golangsf", Members: 100}}
+	partials <- partial{id: "golangsf", group: &Group{ID: "golangsf", Members: 101}}
+	partials <- partial{id: "golang-nyc", group: &Group{ID: "golang-nyc"}}
+	partials <- partial{id: "golang-paris", group: &Group{ID: "golang-paris"}}
+	lc := newLogContext(newTestContext(t))
+	var got []partial
+	ok := collect(lc, []string{"golangsf", "golang-paris", "golang-users-berlin"}, partials, nil, func(p partial) {
+		got = append(got, p)
+		if p.id == "golang-paris" {
+			// the rest never comes
+			timeout <- time.Time{}
+		}
+	})
+	if !ok {
+		t.Fatal("collect returned false, want true")
+	}
+	var ids []string
+	for _, p := range got {
+		ids = append(ids, p.id)
+	}
+	if want := []string{"golangsf", "golang-paris", "golang-users-berlin"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("got results for %q, want %q", ids, want)
+	}
+	if got[0].group.Members != 100 {
+		t.Errorf("got %d members for golangsf, want the first result with 100", got[0].group.Members)
+	}
+	if got[2].err != errTimeout {
+		t.Errorf("golang-users-berlin: got %v, want %v", got[2].err, errTimeout)
+	}
+	want := []string{
+		`error: collect: dropped unexpected result for "golangsf"`,
+		`error: collect: dropped unexpected result for "golang-nyc"`,
+	}
+	if logs := lc.lines(); !reflect.DeepEqual(logs, want) {
+		t.Errorf("got logs %q, want %q", logs, want)
+	}
+}
+
+func TestCollectCanceled(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	called := false
+	ok := collect(newTestContext(t), []string{"golangsf"}, make(chan partial), done, func(partial) { called = true })
+	if ok || called {
+		t.Errorf("collect after done: got %v and emit called %v, want false and not called", ok, called)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	boom := &kindError{ErrNetwork, errors.New("boom")}
+	tests := []struct {
+		name    string
+		prepare func(f *stubFetcher, cache *MemoryCache)
+		want    string // the name of the group, if any
+		cached  bool
+		stale   bool
+		err     error
+		fetches int
+	}{
+		{
+			name:    "cache miss",
+			want:    "GoSF",
+			fetches: 1,
+		},
+		{
+			name: "cache hit",
+			prepare: func(f *stubFetcher, cache *MemoryCache) {
+				cache.Set("golangsf", cachedGroup{Group: &Group{ID: "golangsf", Name: "cached"}}, time.Hour)
+			},
+			want:   "cached",
+			cached: true,
+		},
+		{
+			name: "cached as missing",
+			prepare: func(f *stubFetcher, cache *MemoryCache) {
+				cache.Set("golangsf", cachedGroup{Missing: true}, time.Hour)
+			},
+			cached: true,
+			err:    ErrNotFound,
+		},
+		{
+			name: "not found",
+			prepare: func(f *stubFetcher, cache *MemoryCache) {
+				f.fail("golangsf", ErrNotFound)
+			},
+			err:     ErrNotFound,
+			fetches: 1,
+		},
+		{
+			name: "error",
+			prepare: func(f *stubFetcher, cache *MemoryCache) {
+				f.fail("golangsf", boom)
+			},
+			err:     boom,
+			fetches: 1,
+		},
+		{
+			name: "error with a last good copy",
+			prepare: func(f *stubFetcher, cache *MemoryCache) {
+				f.fail("golangsf", boom)
+				cache.Set(lastGoodKey("golangsf"), &Group{ID: "golangsf", Name: "last good"}, time.Hour)
+			},
+			want:    "last good",
+			cached:  true,
+			stale:   true,
+			fetches: 1,
+		},
+	}
+	for _, tt := range tests {
+		f, cache, restore := setup(testGroups()...)
+		if tt.prepare != nil {
+			tt.prepare(f, cache)
+		}
+		group, cached, err := load(newTestContext(t), cache, f, "golangsf")
+		restore()
+
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+		if got := f.fetches("golangsf"); got != tt.fetches {
+			t.Errorf("%s: got %d fetches, want %d", tt.name, got, tt.fetches)
+		}
+		if cached != tt.cached {
+			t.Errorf("%s: got cached %v, want %v", tt.name, cached, tt.cached)
+		}
+		if tt.want == "" {
+			if group != nil {
+				t.Errorf("%s: got group %+v, want none", tt.name, group)
+			}
+			continue
+		}
+		if group == nil {
+			t.Errorf("%s: got no group, want %q", tt.name, tt.want)
+			continue
+		}
+		if group.Name != tt.want || group.Stale != tt.stale {
+			t.Errorf("%s: got group %q stale %v, want %q stale %v", tt.name, group.Name, group.Stale, tt.want, tt.stale)
+		}
+	}
+}
+
+func TestLoadCaches(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	c := newTestContext(t)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := load(c, cache, f, "golangsf"); err != nil {
+			t.Fatalf("load %d: %v", i, err)
+		}
+		if _, _, err := load(c, cache, f, "nope"); err != ErrNotFound {
+			t.Fatalf("load %d of a missing group: got error %v, want %v", i, err, ErrNotFound)
+		}
+	}
+	// the groups are fetched once, even the missing ones
+	if n := f.fetches("golangsf"); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+	if n := f.fetches("nope"); n != 1 {
+		t.Errorf("got %d fetches of the missing group, want 1", n)
+	}
+
+	// and without cache they're fetched every time
+	cacheTTL = 0
+	load(c, cache, f, "golangsf")
+	if n := f.fetches("golangsf"); n != 2 {
+		t.Errorf("got %d fetches with caching disabled, want 2", n)
+	}
+}
+
+func TestGetGroups(t *testing.T) {
+	boom := &kindError{ErrNetwork, errors.New("boom")}
+	tests := []struct {
+		name   string
+		url    string
+		fail   []string // ids of the groups failing to fetch
+		status int
+		groups []string
+		errs   []fetchError
+	}{
+		{
+			name:   "all groups",
+			url:    "/api/groups",
+			status: http.StatusOK,
+			groups: []string{"golang-users-berlin", "golangsf", "golang-paris"},
+			errs:   []fetchError{},
+		},
+		{
+			name:   "some errors",
+			url:    "/api/groups",
+			fail:   []string{"golangsf", "golang-paris"},
+			status: http.StatusOK,
+			groups: []string{"golang-users-berlin"},
+			errs: []fetchError{
+				{"golang-paris", "network", "boom"},
+				{"golangsf", "network", "boom"},
+			},
+		},
+		{
+			name:   "all errors",
+			url:    "/api/groups",
+			fail:   []string{"golangsf", "golang-paris", "golang-users-berlin"},
+			status: http.StatusBadGateway,
+			groups: []string{},
+			errs: []fetchError{
+				{"golang-paris", "network", "boom"},
+				{"golang-users-berlin", "network", "boom"},
+				{"golangsf", "network", "boom"},
+			},
+		},
+		{
+			name:   "ids",
+			url:    "/api/groups?ids=golangsf,nope",
+			status: http.StatusOK,
+			groups: []string{"golangsf"},
+			errs:   []fetchError{{"nope", "not_found", "unexpected status 404"}},
+		},
+		{
+			name:   "unknown parameter",
+			url:    "/api/groups?nope=1",
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(testGroups()...)
+		for _, id := range tt.fail {
+			f.fail(id, boom)
+		}
+		w := get(t, getGroups, tt.url)
+		restore()
+
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status == http.StatusBadRequest {
+			continue
+		}
+		var res groupsResponse
+		decode(t, w, &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("%s: got groups %q, want %q", tt.name, got, tt.groups)
+		}
+		if !reflect.DeepEqual(res.Errors, tt.errs) {
+			t.Errorf("%s: got errors %+v, want %+v", tt.name, res.Errors, tt.errs)
+		}
+	}
+}
+
+func TestGetGroupsCountry(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	f.fail("golang-paris", &kindError{ErrNetwork, errors.New("boom")})
+
+	w := get(t, getGroups, "/api/groups?country=US")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var res groupsResponse
+	decode(t, w, &res)
+	if got, want := groupIDs(res.Groups), []string{"golangsf"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got groups %q, want %q", got, want)
+	}
+	// the errors of the groups in other countries are still reported
+	if len(res.Errors) != 1 || res.Errors[0].ID != "golang-paris" {
+		t.Errorf("got errors %+v, want one for golang-paris", res.Errors)
+	}
+}
+
+func TestSortGroups(t *testing.T) {
+	groups := func() []*Group {
+		return []*Group{
+			{ID: "b", Name: "B", Members: 10},
+			{ID: "d", Name: "D", Members: 20},
+			{ID: "a", Name: "A", Members: 20},
+			{ID: "c", Name: "C", Members: 5},
+		}
+	}
+	tests := []struct {
+		key  string
+		want []string
+	}{
+		{"", []string{"a", "b", "c", "d"}},
+		{"name", []string{"a", "b", "c", "d"}},
+		{"-name", []string{"d", "c", "b", "a"}},
+		// ties are sorted by name
+		{"members", []string{"c", "b", "a", "d"}},
+		{"-members", []string{"a", "d", "b", "c"}},
+		{"nope", []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		g := groups()
+		sortGroups(g, tt.key)
+		if got := groupIDs(g); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortGroups(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/api/group/golangsf", http.StatusOK},
+		{"/api/group/nope", http.StatusNotFound},
+		{"/api/group/", http.StatusBadRequest},
+		{"/api/group/golang%20sf", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		w := get(t, getGroup, tt.url)
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("GET %s: got status %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var g Group
+		decode(t, w, &g)
+		if g.ID != "golangsf" || g.Name != "GoSF" {
+			t.Errorf("GET %s: got group %q named %q, want golangsf named GoSF", tt.url, g.ID, g.Name)
+		}
+	}
+}
+
+// meetupServer serves the meetup API requests with h, and returns a function
+// stopping it and restoring apiBaseURL. Retries don't wait.
+func meetupServer(h http.HandlerFunc) func() {
+	srv := httptest.NewServer(h)
+	oldURL, oldSleep := apiBaseURL, sleep
+	apiBaseURL = srv.URL
+	sleep = func(time.Duration) {}
+	return func() {
+		srv.Close()
+		apiBaseURL, sleep = oldURL, oldSleep
+	}
+}
+
+func TestGetWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // of the responses, the last one repeated
+		timeout  time.Duration
+		status   int
+		requests int
+	}{
+		{"success", []int{200}, time.Minute, 200, 1},
+		{"fails twice", []int{500, 503, 200}, time.Minute, 200, 3},
+		{"always fails", []int{500}, time.Minute, 500, retries + 1},
+		{"not found", []int{404, 200}, time.Minute, 404, 1},
+		{"no time to retry", []int{500, 200}, retryDelay / 2, 500, 1},
+	}
+	for _, tt := range tests {
+		n := 0
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			status := tt.statuses[len(tt.statuses)-1]
+			if n < len(tt.statuses) {
+				status = tt.statuses[n]
+			}
+			n++
+			w.WriteHeader(status)
+		})
+		oldTimeout := requestTimeout
+		requestTimeout = tt.timeout
+		req, _ := http.NewRequest("GET", apiBaseURL+"/golangsf", nil)
+		res, err := getWithRetry(http.DefaultClient, req, time.Now().Add(requestTimeout))
+		requestTimeout = oldTimeout
+		stop()
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.status || n != tt.requests {
+			t.Errorf("%s: got status %d after %d requests, want %d after %d", tt.name, res.StatusCode, n, tt.status, tt.requests)
+		}
+	}
+}
+
+// BenchmarkFetchWorkers compares the pool of workers with fetching every
+// group in its own goroutine.
+func BenchmarkFetchWorkers(b *testing.B) {
+	var groups []*Group
+	for i := 0; i < 500; i++ {
+		groups = append(groups, &Group{ID: fmt.Sprintf("group-%d", i), Members: i})
+	}
+	_, cache, restore := setup(groups...)
+	defer restore()
+	cacheTTL = 0
+	c := newTestContext(b)
+
+	for _, bb := range []struct {
+		name string
+		n    int
+	}{
+		{"pool", workers},
+		{"goroutine per id", len(ids)},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				collect(c, ids, fetchWorkers(c, cache, ids, false, bb.n, nil), nil, func(partial) {})
+			}
+		})
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		kind   error
+		msg    string
+	}{
+		{http.StatusUnauthorized, ErrMeetupAPI, `unexpected status 401: invalid key`},
+		{http.StatusNotFound, ErrNotFound, "unexpected status 404"},
+		{http.StatusInternalServerError, ErrMeetupAPI, `unexpected status 500: invalid key`},
+	}
+	defer setKeys("test-key")()
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, `{"errors": [{"code": "auth_fail", "message": "invalid key"}]}`)
+		})
+		g, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+
+		if g != nil || err == nil {
+			t.Errorf("status %d: got group %+v and error %v, want an error", tt.status, g, err)
+			continue
+		}
+		if Kind(err) != tt.kind || err.Error() != tt.msg {
+			t.Errorf("status %d: got error %q of kind %v, want %q of kind %v", tt.status, err, Kind(err), tt.msg, tt.kind)
+		}
+	}
+}
+
+// closeBody is a response body recording whether it was closed.
+type closeBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// roundTripper is an http.RoundTripper calling a function.
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestGetWithRetryClosesBodies(t *testing.T) {
+	defer func(old func(time.Duration)) { sleep = old }(sleep)
+	sleep = func(time.Duration) {}
+
+	var bodies []*closeBody
+	statuses := []int{500, 429, 200}
+	client := &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		b := &closeBody{Reader: strings.NewReader("{}")}
+		bodies = append(bodies, b)
+		res := &http.Response{StatusCode: statuses[len(bodies)-1], Body: b, Header: http.Header{}}
+		res.Header.Set("Retry-After", "0")
+		return res, nil
+	})}
+	req, _ := http.NewRequest("GET", "http://meetup.test/golangsf", nil)
+	res, err := getWithRetry(client, req, time.Now().Add(requestTimeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || len(bodies) != 3 {
+		t.Fatalf("got status %d after %d requests, want 200 after 3", res.StatusCode, len(bodies))
+	}
+	// the bodies of the retried responses are closed, the caller closes
+	// the last one
+	for i, b := range bodies[:2] {
+		if !b.closed {
+			t.Errorf("body of response %d not closed", i)
+		}
+	}
+	if bodies[2].closed {
+		t.Error("body of the returned response closed")
+	}
+}
+
+func TestGetGroupsETag(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	responseTTL = 0 // encode every response again
+
+	first := get(t, getGroups, "/api/groups")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Errorf("got Cache-Control %q, want %q", cc, "public, max-age=3600")
+	}
+
+	// identical groups get the same ETag
+	if second := get(t, getGroups, "/api/groups"); second.Header().Get("ETag") != etag {
+		t.Errorf("got ETag %q the second time, want %q", second.Header().Get("ETag"), etag)
+	}
+
+	r := newRequest(t, "GET", "/api/groups", nil)
+	r.Header.Set("If-None-Match", etag)
+	w := serve(getGroups, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("with If-None-Match: got status %d and %d bytes, want %d and none", w.Code, w.Body.Len(), http.StatusNotModified)
+	}
+
+	r = newRequest(t, "GET", "/api/groups", nil)
+	r.Header.Set("If-None-Match", `"other"`)
+	if w := serve(getGroups, r); w.Code != http.StatusOK {
+		t.Errorf("with another If-None-Match: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestGetGroupETag(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+
+	first := get(t, getGroup, "/api/group/golangsf")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if other := get(t, getGroup, "/api/group/golang-paris"); other.Header().Get("ETag") == etag {
+		t.Errorf("two groups got the same ETag %q", etag)
+	}
+
+	r := newRequest(t, "GET", "/api/group/golangsf", nil)
+	r.Header.Set("If-None-Match", etag)
+	w := serve(getGroup, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("with If-None-Match: got status %d and %d bytes, want %d and none", w.Code, w.Body.Len(), http.StatusNotModified)
+	}
+	if got := w.Header().Get("ETag"); got != etag {
+		t.Errorf("with If-None-Match: got ETag %q, want %q", got, etag)
+	}
+
+	// once the group changes so does its ETag
+	cache.Delete("golangsf")
+	f.mu.Lock()
+	f.groups["golangsf"] = &Group{ID: "golangsf", Name: "GoSF", Members: 101}
+	f.mu.Unlock()
+	r = newRequest(t, "GET", "/api/group/golangsf", nil)
+	r.Header.Set("If-None-Match", etag)
+	if w := serve(getGroup, r); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("after a change: got status %d and ETag %q, want %d and another ETag", w.Code, w.Header().Get("ETag"), http.StatusOK)
+	}
+}
+
+func TestGetGroupsGzip(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+
+	r := newRequest(t, "GET", "/api/groups", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := serve(getGroups, r)
+	if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", ce)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res groupsResponse
+	if err := json.NewDecoder(gz).Decode(&res); err != nil {
+		t.Fatalf("decode gzipped response: %v", err)
+	}
+	if len(res.Groups) != 3 {
+		t.Errorf("got %d groups, want 3", len(res.Groups))
+	}
+
+	// without Accept-Encoding the same response is not compressed
+	w = get(t, getGroups, "/api/groups")
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("without Accept-Encoding: got Content-Encoding %q", ce)
+	}
+	decode(t, w, &res)
+}
+
+func TestCoordinates(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		body     string
+		lat, lon float64
+	}{
+		{`{"name": "GoSF", "lat": 37.77, "lon": -122.41}`, 37.77, -122.41},
+		{`{"name": "GoSF"}`, 0, 0},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		})
+		c := newTestContext(t)
+		g, err := fetch(c, "golangsf", time.Time{})
+		stop()
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+
+		// the coordinates survive memcache
+		cache := memcacheCache{c}
+		if err := cache.Set("coordinates-test", cachedGroup{Group: g}, time.Minute); err != nil {
+			t.Fatalf("cache set: %v", err)
+		}
+		var cg cachedGroup
+		if err := cache.Get("coordinates-test", &cg); err != nil {
+			t.Fatalf("cache get: %v", err)
+		}
+		if cg.Group.Lat != tt.lat || cg.Group.Lon != tt.lon {
+			t.Errorf("%s: got coordinates %v, %v, want %v, %v", tt.body, cg.Group.Lat, cg.Group.Lon, tt.lat, tt.lon)
+		}
+	}
+}
+
+func TestGetGroupsTotalMembers(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	f.fail("golang-paris", &kindError{ErrNetwork, errors.New("boom")})
+
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups"), &res)
+	// golang-paris failed, so its 50 members are not counted
+	if want := 100 + 80; res.TotalMembers != want {
+		t.Errorf("got %d total members, want %d", res.TotalMembers, want)
+	}
+}
+
+func TestGetGroupsDedup(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	defer setEnv("GROUP_IDS", str("golangsf,golang-paris,golangsf"))()
+	ids = loadIDs()
+
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups"), &res)
+	if got, want := groupIDs(res.Groups), []string{"golangsf", "golang-paris"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got groups %q, want %q", got, want)
+	}
+	if n := f.fetches("golangsf"); n != 1 {
+		t.Errorf("golangsf fetched %d times, want 1", n)
+	}
+
+	// the ids given in the request too
+	flushResponses()
+	decode(t, get(t, getGroups, "/api/groups?ids=golang-users-berlin,golang-users-berlin"), &res)
+	if got, want := groupIDs(res.Groups), []string{"golang-users-berlin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("with ids: got groups %q, want %q", got, want)
+	}
+	if n := f.fetches("golang-users-berlin"); n != 1 {
+		t.Errorf("golang-users-berlin fetched %d times, want 1", n)
+	}
+}
+
+func TestLoadSoftTTL(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	softTTL = time.Minute
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+	var queued []string
+	defer func(old func(appengine.Context, string)) { queueRefresh = old }(queueRefresh)
+	queueRefresh = func(c appengine.Context, id string) {
+		queued = append(queued, id)
+		refresh(c, cache, f, id, nil)
+	}
+	c := newTestContext(t)
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		members int // of the group on meetup
+		want    int // members in the loaded group
+		cached  bool
+		queued  int
+		fetches int
+	}{
+		{"first", 0, 100, 100, false, 0, 1},
+		{"fresh", 30 * time.Second, 110, 100, true, 0, 1},
+		// the stale copy is served, and refreshed
+		{"stale", 2 * time.Minute, 120, 100, true, 1, 2},
+		{"refreshed", 2*time.Minute + time.Second, 130, 120, true, 1, 2},
+		{"expired", 2 * time.Hour, 140, 140, false, 1, 3},
+	}
+	for _, tt := range tests {
+		clock = start.Add(tt.elapsed)
+		f.mu.Lock()
+		f.groups["golangsf"].Members = tt.members
+		f.mu.Unlock()
+
+		g, cached, err := load(c, cache, f, "golangsf")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if g.Members != tt.want || cached != tt.cached {
+			t.Errorf("%s: got %d members cached %v, want %d cached %v", tt.name, g.Members, cached, tt.want, tt.cached)
+		}
+		if len(queued) != tt.queued || f.fetches("golangsf") != tt.fetches {
+			t.Errorf("%s: got %d refreshes and %d fetches, want %d and %d", tt.name, len(queued), f.fetches("golangsf"), tt.queued, tt.fetches)
+		}
+	}
+}
+
+func TestLoadSoftTTLQueuedOnce(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	softTTL = time.Minute
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+	// the refreshes are only queued, the test runs them
+	var queued []string
+	defer func(old func(appengine.Context, string)) { queueRefresh = old }(queueRefresh)
+	queueRefresh = func(c appengine.Context, id string) { queued = append(queued, id) }
+	c := newTestContext(t)
+	load(c, cache, f, "golangsf")
+
+	boom := &kindError{ErrNetwork, errors.New("boom")}
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		queued  int
+		run     bool  // the queued refreshes after loading
+		err     error // of their fetches
+	}{
+		// two stale hits before the refresh runs queue it once
+		{"stale", 2 * time.Minute, 1, false, nil},
+		{"stale again", 2*time.Minute + time.Second, 1, true, nil},
+		// once it's done the group is fresh, and then stale again
+		{"refreshed", 2*time.Minute + 2*time.Second, 1, false, nil},
+		{"stale after the refresh", 4 * time.Minute, 2, true, boom},
+		// a failed refresh is queued again only after refreshingTTL
+		{"refresh failed", 4*time.Minute + time.Second, 2, false, nil},
+		{"refresh failed a while ago", 4*time.Minute + refreshingTTL + time.Second, 3, false, nil},
+	}
+	ran := 0
+	for _, tt := range tests {
+		clock = start.Add(tt.elapsed)
+		if _, cached, err := load(c, cache, f, "golangsf"); err != nil || !cached {
+			t.Fatalf("%s: got cached %v, %v; want the cached group", tt.name, cached, err)
+		}
+		if len(queued) != tt.queued {
+			t.Errorf("%s: got %d refreshes queued, want %d", tt.name, len(queued), tt.queued)
+		}
+		if !tt.run {
+			continue
+		}
+		f.mu.Lock()
+		delete(f.errs, "golangsf")
+		if tt.err != nil {
+			f.errs["golangsf"] = tt.err
+		}
+		f.mu.Unlock()
+		for _, id := range queued[ran:] {
+			refresh(c, cache, f, id, nil)
+		}
+		ran = len(queued)
+	}
+}
+
+// memberStub is a stubFetcher that can fetch only the member counts too.
+type memberStub struct {
+	*stubFetcher
+	err   error // returned by FetchMembers when set
+	calls int
+}
+
+func (f *memberStub) FetchMembers(c appengine.Context, id string) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return 0, f.err
+	}
+	g, ok := f.groups[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return g.Members, nil
+}
+
+func TestPartialRefresh(t *testing.T) {
+	sf, cache, restore := setup(testGroups()...)
+	defer restore()
+	f := &memberStub{stubFetcher: sf}
+	softTTL = time.Minute
+	defer func(old bool) { partialRefresh = old }(partialRefresh)
+	partialRefresh = true
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+	defer func(old func(appengine.Context, string)) { queueRefresh = old }(queueRefresh)
+	queueRefresh = func(c appengine.Context, id string) {
+		var cg cachedGroup
+		cache.Get(id, &cg)
+		refresh(c, cache, f, id, &cg)
+	}
+	c := newTestContext(t)
+	sf.groups["golangsf"].Fetched = start
+	first, _, err := load(c, cache, f, "golangsf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boom := &kindError{ErrNetwork, errors.New("boom")}
+	tests := []struct {
+		name       string
+		elapsed    time.Duration
+		groupName  string // of the group on meetup
+		members    int
+		membersErr error
+		want       *Group // the loaded group
+		cached     bool
+		calls      int // member count fetches
+		fetches    int
+	}{
+		// only the member count of the stale copy is refreshed
+		{"stale", 2 * time.Minute, "GoSF!", 120, nil, first, true, 1, 1},
+		{"member count refreshed", 2*time.Minute + time.Second, "GoSF!", 120, nil, &Group{Name: "GoSF", Members: 120, Fetched: start}, true, 1, 1},
+		// and the whole group when that fails
+		{"failed", 4 * time.Minute, "GoSF!", 130, boom, &Group{Name: "GoSF", Members: 120, Fetched: start}, true, 2, 2},
+		{"group refreshed", 4*time.Minute + time.Second, "GoSF!", 130, nil, &Group{Name: "GoSF!", Members: 130, Fetched: start.Add(4 * time.Minute)}, true, 2, 2},
+		// the member counts don't keep the group cached past its expiry
+		{"stale again", 60 * time.Minute, "GoSF!!", 140, nil, &Group{Name: "GoSF!", Members: 130, Fetched: start.Add(4 * time.Minute)}, true, 3, 2},
+		{"expired", 64*time.Minute + time.Second, "GoSF!!", 150, nil, &Group{Name: "GoSF!!", Members: 150, Fetched: start.Add(64*time.Minute + time.Second)}, false, 3, 3},
+	}
+	for _, tt := range tests {
+		clock = start.Add(tt.elapsed)
+		f.mu.Lock()
+		f.groups["golangsf"].Name = tt.groupName
+		f.groups["golangsf"].Members = tt.members
+		f.groups["golangsf"].Fetched = clock
+		f.err = tt.membersErr
+		f.mu.Unlock()
+
+		g, cached, err := load(c, cache, f, "golangsf")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := *first
+		want.Name, want.Members, want.Fetched = tt.want.Name, tt.want.Members, tt.want.Fetched
+		if !reflect.DeepEqual(*g, want) || cached != tt.cached {
+			t.Errorf("%s: got %+v cached %v, want %+v cached %v", tt.name, g, cached, &want, tt.cached)
+		}
+		f.mu.Lock()
+		calls := f.calls
+		f.mu.Unlock()
+		if calls != tt.calls || f.fetches("golangsf") != tt.fetches {
+			t.Errorf("%s: got %d member count fetches and %d fetches, want %d and %d", tt.name, calls, f.fetches("golangsf"), tt.calls, tt.fetches)
+		}
+	}
+}
+
+func TestGetGroupsCanceled(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	f.wait = make(chan struct{})
+	defer close(f.wait)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newRequest(t, "GET", "/api/groups", nil).WithContext(ctx)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(getGroups, r) }()
+	cancel()
+
+	select {
+	case w := <-done:
+		if w.Body.Len() != 0 {
+			t.Errorf("got a response to a canceled request: %q", w.Body.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("getGroups didn't return after the request was canceled")
+	}
+}
+
+func TestGetGroupsStream(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	f.fail("golang-paris", ErrNotFound)
+
+	w := get(t, getGroups, "/api/groups?stream=1")
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("got Content-Type %q, want application/x-ndjson", ct)
+	}
+	var groups, errs []string
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var line struct {
+			ID    string
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("decode line %q: %v", sc.Text(), err)
+		}
+		if line.Error != "" {
+			errs = append(errs, line.Error)
+		} else {
+			groups = append(groups, line.ID)
+		}
+	}
+	sort.Strings(groups)
+	if want := []string{"golang-users-berlin", "golangsf"}; !reflect.DeepEqual(groups, want) {
+		t.Errorf("got groups %q, want %q", groups, want)
+	}
+	if want := []string{"fetch golang-paris: unexpected status 404"}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("got errors %q, want %q", errs, want)
+	}
+}
+
+// failingWriter is a ResponseWriter whose writes fail after the first n.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	n int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errors.New("client gone")
+	}
+	w.n--
+	return w.ResponseRecorder.Write(b)
+}
+
+// blockFetcher is a Fetcher whose fetches of the groups other than first
+// wait for unblock to be closed.
+type blockFetcher struct {
+	Fetcher
+	first   string
+	unblock chan struct{}
+}
+
+func (f blockFetcher) Fetch(c appengine.Context, id string) (*Group, error) {
+	if id != f.first {
+		<-f.unblock
+	}
+	return f.Fetcher.Fetch(c, id)
+}
+
+func TestGetGroupsStreamWriteError(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	unblock := make(chan struct{})
+	fetcher = blockFetcher{f, "golangsf", unblock}
+
+	// the handler returns once the first group can't be written, without
+	// waiting for the others
+	w := &failingWriter{httptest.NewRecorder(), 0}
+	done := make(chan struct{})
+	go func() {
+		getGroups(w, newRequest(t, "GET", "/api/groups?stream=1", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler is still streaming after the write failed")
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("got %q written, want nothing", w.Body)
+	}
+
+	// the blocked loads finish before restore resets the state they use
+	close(unblock)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatalf("the fetches are still blocked after the handler returned: %v", err)
+	}
+	for _, id := range []string{"golang-paris", "golang-users-berlin"} {
+		var cg cachedGroup
+		if err := cache.Get(id, &cg); err != nil {
+			t.Errorf("%s was not cached after the handler returned: %v", id, err)
+		}
+	}
+}
+
+func TestCountGroups(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/api/groups/count", `{"apiVersion":"2","configured":3,"available":2,"errors":1}`},
+		{"/api/groups/count?cheap=1", `{"apiVersion":"2","configured":3}`},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(testGroups()...)
+		f.fail("golangsf", &kindError{ErrNetwork, errors.New("boom")})
+		w := get(t, countGroups, tt.url)
+		restore()
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("GET %s: got %s, want %s", tt.url, got, tt.want)
+		}
+		// the cheap count doesn't fetch anything
+		if n := f.fetches("golangsf"); tt.url == "/api/groups/count?cheap=1" && n != 0 {
+			t.Errorf("GET %s: fetched %d groups, want none", tt.url, n)
+		}
+	}
+}
+
+func TestInvalidIDs(t *testing.T) {
+	defer setKeys("test-key")()
+	requests := 0
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) { requests++ })()
+
+	for _, id := range []string{"golang/sf", "golang sf", "golangsf?x=1", ""} {
+		_, err := fetch(newTestContext(t), id, time.Time{})
+		if want := fmt.Sprintf("invalid id %q", id); err == nil || err.Error() != want {
+			t.Errorf("fetch(%q): got error %v, want %s", id, err, want)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("got %d requests to meetup, want none", requests)
+	}
+
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	w := get(t, getGroups, "/api/groups?ids=golangsf,golang%2Fsf")
+	var res errorResponse
+	decode(t, w, &res)
+	if w.Code != http.StatusBadRequest || res.Error != `invalid id "golang/sf"` {
+		t.Errorf("got status %d and error %q, want %d and %q", w.Code, res.Error, http.StatusBadRequest, `invalid id "golang/sf"`)
+	}
+}
+
+func TestFlexInt(t *testing.T) {
+	tests := []struct {
+		json string
+		want flexInt
+		ok   bool
+	}{
+		{`123`, 123, true},
+		{`"123"`, 123, true},
+		{`null`, 0, true},
+		{`"many"`, 0, false},
+		{`12.5`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, tt := range tests {
+		var n flexInt
+		err := json.Unmarshal([]byte(tt.json), &n)
+		if (err == nil) != tt.ok || n != tt.want {
+			t.Errorf("unmarshal %s: got %d, %v; want %d and ok %v", tt.json, n, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	defer setKeys("test-key")()
+	release := make(chan struct{})
+	stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"name": "GoSF"}`)
+	})
+	defer stop()
+	defer close(release)
+	defer func(fetch, request time.Duration) {
+		fetchTimeout, requestTimeout = fetch, request
+	}(fetchTimeout, requestTimeout)
+	// too short to retry
+	fetchTimeout, requestTimeout = 20*time.Millisecond, retryDelay/2
+
+	start := time.Now()
+	_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+	if Kind(err) != ErrNetwork {
+		t.Errorf("got error %v of kind %v, want a network error", err, Kind(err))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("fetch took %v with a timeout of %v", d, fetchTimeout)
+	}
+}
+
+func TestFetchedTime(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	fetched := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := fetched
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+	f.groups["golangsf"].Fetched = fetched
+	c := newTestContext(t)
+
+	load(c, cache, f, "golangsf")
+	clock = clock.Add(10 * time.Minute)
+	g, cached, err := load(c, cache, f, "golangsf")
+	if err != nil || !cached {
+		t.Fatalf("second load: got cached %v and error %v, want a cached group", cached, err)
+	}
+	if !g.Fetched.Equal(fetched) {
+		t.Errorf("got Fetched %v, want %v", g.Fetched, fetched)
+	}
+
+	b, _ := json.Marshal(g)
+	if want := `"Fetched":"2017-01-01T12:00:00Z"`; !strings.Contains(string(b), want) {
+		t.Errorf("got %s, want it to contain %s", b, want)
+	}
+}
+
+func TestGetGroupsNoIDs(t *testing.T) {
+	_, _, restore := setup()
+	defer restore()
+
+	w := get(t, getGroups, "/api/groups")
+	var res errorResponse
+	decode(t, w, &res)
+	if w.Code != http.StatusInternalServerError || res.Error != errNoIDs.Error() {
+		t.Errorf("got status %d and error %q, want %d and %q", w.Code, res.Error, http.StatusInternalServerError, errNoIDs)
+	}
+}
+
+func TestUnknownParams(t *testing.T) {
+	tests := []struct {
+		url    string
+		status int
+		err    string
+	}{
+		{"/api/groups?frmat=csv", http.StatusBadRequest, "unknown parameters: frmat"},
+		{"/api/groups?sort=name&b=1&a=2", http.StatusBadRequest, "unknown parameters: a, b"},
+		{"/api/groups?sort=-members&country=us&format=json", http.StatusOK, ""},
+		{"/api/groups?callback=jQuery123&_=1500000000000", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		w := get(t, getGroups, tt.url)
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("GET %s: got status %d, want %d", tt.url, w.Code, tt.status)
+			continue
+		}
+		if tt.err == "" {
+			continue
+		}
+		var res errorResponse
+		decode(t, w, &res)
+		if res.Error != tt.err {
+			t.Errorf("GET %s: got error %q, want %q", tt.url, res.Error, tt.err)
+		}
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		limited int // how many requests are rate limited
+		err     error
+		slept   time.Duration
+	}{
+		{1, nil, time.Second},
+		{2, ErrRateLimited, time.Second},
+	}
+	for _, tt := range tests {
+		n := 0
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			n++
+			if n <= tt.limited {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, `{"name": "GoSF"}`)
+		})
+		var slept time.Duration
+		sleep = func(d time.Duration) { slept += d }
+		before := atomic.LoadInt64(&metrics.RateLimited)
+		_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+
+		if err != tt.err || slept != tt.slept {
+			t.Errorf("%d rate limited requests: got error %v after sleeping %v, want %v after %v", tt.limited, err, slept, tt.err, tt.slept)
+		}
+		if got := atomic.LoadInt64(&metrics.RateLimited) - before; got != int64(tt.limited) {
+			t.Errorf("%d rate limited requests: counted %d", tt.limited, got)
+		}
+	}
+	if e := newFetchError("golangsf", ErrRateLimited); e.Kind != "rate_limited" {
+		t.Errorf("got kind %q for %v, want rate_limited", e.Kind, ErrRateLimited)
+	}
+}
+
+func TestCountryCode(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		country, code, name string
+	}{
+		{"de", "DE", "Germany"},
+		{"US", "US", "United States"},
+		{"xx", "XX", ""},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"name": "Go", "country": %q}`, tt.country)
+		})
+		g, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err != nil {
+			t.Errorf("country %q: %v", tt.country, err)
+			continue
+		}
+		if g.CountryCode != tt.code || g.CountryName != tt.name {
+			t.Errorf("country %q: got code %q and name %q, want %q and %q", tt.country, g.CountryCode, g.CountryName, tt.code, tt.name)
+		}
+	}
+}
+
+func TestLoadCoalesces(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	f.wait = make(chan struct{})
+	c := newTestContext(t)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g, _, err := load(c, cache, f, "golangsf")
+			if err == nil && g.ID != "golangsf" {
+				err = fmt.Errorf("got group %q", g.ID)
+			}
+			errs <- err
+		}()
+	}
+	// let them all find the fetch in progress
+	time.Sleep(50 * time.Millisecond)
+	close(f.wait)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := f.fetches("golangsf"); got != 1 {
+		t.Errorf("got %d fetches for %d concurrent loads, want 1", got, n)
+	}
+}
+
+func TestGetGroupsPage(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		groups []string
+	}{
+		{"limit=2", http.StatusOK, []string{"golang-users-berlin", "golangsf"}},
+		{"limit=2&offset=2", http.StatusOK, []string{"golang-paris"}},
+		{"offset=3", http.StatusOK, []string{}},
+		{"offset=10", http.StatusOK, []string{}},
+		{"limit=10", http.StatusOK, []string{"golang-users-berlin", "golangsf", "golang-paris"}},
+		{"limit=0", http.StatusOK, []string{}},
+		{"limit=-1", http.StatusBadRequest, nil},
+		{"limit=1001", http.StatusBadRequest, nil},
+		{"offset=-1", http.StatusBadRequest, nil},
+		{"limit=x", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		w := get(t, getGroups, "/api/groups?"+tt.query)
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.query, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var res groupsResponse
+		decode(t, w, &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("%s: got groups %q, want %q", tt.query, got, tt.groups)
+		}
+		if res.Page == nil || res.Page.Total != 3 {
+			t.Errorf("%s: got page %+v, want a total of 3", tt.query, res.Page)
+		}
+	}
+}
+
+func TestGetGroupsIDs(t *testing.T) {
+	defer func(old int) { maxIDs = old }(maxIDs)
+	maxIDs = 2
+	tests := []struct {
+		ids    string
+		status int
+		groups []string
+	}{
+		{"golang-paris", http.StatusOK, []string{"golang-paris"}},
+		{"golangsf,golang-paris,golangsf", http.StatusOK, []string{"golangsf", "golang-paris"}},
+		{"golangsf,golang-paris,golang-users-berlin", http.StatusBadRequest, nil},
+		{"golangsf,golang_paris!", http.StatusBadRequest, nil},
+		{",", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(testGroups()...)
+		w := get(t, getGroups, "/api/groups?ids="+tt.ids)
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("ids=%s: got status %d, want %d", tt.ids, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			if n := f.fetches("golangsf"); n != 0 {
+				t.Errorf("ids=%s: fetched golangsf %d times, want none", tt.ids, n)
+			}
+			continue
+		}
+		var res groupsResponse
+		decode(t, w, &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("ids=%s: got groups %q, want %q", tt.ids, got, tt.groups)
+		}
+	}
+}
+
+func TestGetGroupsLastGood(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	responseTTL = 0
+	get(t, getGroups, "/api/groups")
+
+	// the cached groups expire while meetup is down
+	f.fail("golangsf", &kindError{ErrNetwork, errors.New("boom")})
+	cache.Delete("golangsf")
+	cache.Delete(allGroupsKey(ids))
+
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups"), &res)
+	if len(res.Groups) != 3 || len(res.Errors) != 0 {
+		t.Fatalf("got %d groups and errors %+v, want 3 groups and no errors", len(res.Groups), res.Errors)
+	}
+	for _, g := range res.Groups {
+		if stale := g.ID == "golangsf"; g.Stale != stale {
+			t.Errorf("%s: got stale %v, want %v", g.ID, g.Stale, stale)
+		}
+	}
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	defer setKeys("test-key")()
+	var got *http.Request
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, `{"name": "GoSF"}`)
+	})()
+
+	if _, err := fetch(newTestContext(t), "golangsf", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got == nil {
+		t.Fatal("no request to the test server")
+	}
+	if want := "/golangsf?key=test-key&sign=true"; got.URL.String() != want {
+		t.Errorf("got request for %s, want %s", got.URL, want)
+	}
+	if ua := got.Header.Get("User-Agent"); ua != userAgent {
+		t.Errorf("got User-Agent %q, want %q", ua, userAgent)
+	}
+}
+
+func TestGetGroupsDebug(t *testing.T) {
+	_, cache, restore := setup(testGroups()...)
+	defer restore()
+	// golangsf is already cached, the rest are not
+	cache.Set("golangsf", cachedGroup{Group: testGroups()[0]}, time.Hour)
+
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups?debug=1"), &res)
+	want := map[string]string{
+		"golangsf":            "cache",
+		"golang-paris":        "network",
+		"golang-users-berlin": "network",
+	}
+	if !reflect.DeepEqual(res.Debug, want) {
+		t.Errorf("got sources %v, want %v", res.Debug, want)
+	}
+
+	// and there are none without debug=1
+	w := get(t, getGroups, "/api/groups")
+	if strings.Contains(w.Body.String(), `"Debug"`) {
+		t.Errorf("got sources without debug=1: %s", w.Body)
+	}
+}
+
+func TestGetGroupsEncodeError(t *testing.T) {
+	// NaN can't be encoded as JSON
+	_, _, restore := setup(&Group{ID: "golangsf", Lat: math.NaN()})
+	defer restore()
+
+	w := get(t, getGroups, "/api/groups")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got, want := strings.TrimSpace(w.Body.String()), `{"error":"internal encoding error"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// conditionalStub is a stubFetcher that is also a conditionalFetcher, whose
+// groups are never modified.
+type conditionalStub struct {
+	*stubFetcher
+}
+
+func (f conditionalStub) FetchIfModified(c appengine.Context, id string, since time.Time) (*Group, error) {
+	if f.wait != nil {
+		<-f.wait
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[id+"|conditional"]++
+	return nil, errNotModified
+}
+
+func TestFetchNotModified(t *testing.T) {
+	defer setKeys("test-key")()
+	var since string
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		since = r.Header.Get("If-Modified-Since")
+		w.WriteHeader(http.StatusNotModified)
+	})()
+
+	fetched := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err := fetch(newTestContext(t), "golangsf", fetched)
+	if err != errNotModified {
+		t.Errorf("got error %v, want %v", err, errNotModified)
+	}
+	if want := "Sun, 01 Jan 2017 12:00:00 GMT"; since != want {
+		t.Errorf("got If-Modified-Since %q, want %q", since, want)
+	}
+}
+
+func TestLoadNotModified(t *testing.T) {
+	stub, cache, restore := setup(testGroups()...)
+	defer restore()
+	f := conditionalStub{stub}
+	fetched := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache.Set(lastGoodKey("golangsf"), &Group{ID: "golangsf", Name: "last good", Fetched: fetched}, time.Hour)
+	c := newTestContext(t)
+
+	// the last copy is served as fresh, and cached again
+	g, cached, err := load(c, cache, f, "golangsf")
+	if err != nil || cached || g.Name != "last good" || g.Stale {
+		t.Fatalf("got %+v, cached %v, error %v; want the fresh last copy", g, cached, err)
+	}
+	if !g.Fetched.After(fetched) {
+		t.Errorf("got Fetched %v, want it renewed", g.Fetched)
+	}
+	var cg cachedGroup
+	if err := cache.Get("golangsf", &cg); err != nil || cg.Group.Name != "last good" {
+		t.Errorf("got cached %+v, %v; want the last copy", cg.Group, err)
+	}
+}
+
+func TestFetchOnceConditional(t *testing.T) {
+	stub, _, restore := setup(testGroups()...)
+	defer restore()
+	f := conditionalStub{stub}
+	f.wait = make(chan struct{})
+	c := newTestContext(t)
+
+	// a load without a previous copy doesn't wait for a conditional fetch
+	// in progress, which can't give it the group
+	conditional := make(chan error)
+	go func() {
+		_, err := fetchOnce(c, f, "golangsf", &Group{ID: "golangsf"})
+		conditional <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	plain := make(chan *Group)
+	go func() {
+		g, _ := fetchOnce(c, f, "golangsf", nil)
+		plain <- g
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(f.wait)
+
+	if g := <-plain; g == nil || g.ID != "golangsf" {
+		t.Errorf("got %+v, want the group", g)
+	}
+	if err := <-conditional; err != errNotModified {
+		t.Errorf("conditional fetch: got error %v, want %v", err, errNotModified)
+	}
+}
+
+func TestFeaturedGroups(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	featuredIDs = []string{"golangsf", "nope", "golang-paris"}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", []string{"golangsf", "golang-paris", "golang-users-berlin"}},
+		{"-members", []string{"golangsf", "golang-paris", "golang-users-berlin"}},
+	}
+	for _, tt := range tests {
+		var res groupsResponse
+		decode(t, get(t, getGroups, "/api/groups?sort="+tt.sort), &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort=%s: got %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+
+	// the rest keep their order
+	groups := []*Group{{ID: "d"}, {ID: "c"}, {ID: "golang-paris"}, {ID: "b"}}
+	if got, want := groupIDs(featureGroups(groups)), []string{"golang-paris", "d", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("featureGroups: got %q, want %q", got, want)
+	}
+}
+
+func TestMeetupErrors(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"errors": [{"code": "a", "message": "first"}, {"code": "b"}]}`, "first: b"},
+		{`{"errors": {"code": "throttled", "message": "slow down"}}`, "slow down"},
+		{`{"error": "invalid_token", "error_description": "the token expired"}`, "invalid_token: the token expired"},
+		{`{"error": {"code": "oops"}}`, "oops"},
+		{`{"problem": "Not found", "details": "no such group"}`, "Not found: no such group"},
+		{`{"name": "GoSF"}`, ""},
+		{`<html>`, ""},
+	}
+	for _, tt := range tests {
+		if got := meetupErrors([]byte(tt.body)); got != tt.want {
+			t.Errorf("meetupErrors(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestFetchErrorBody(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusOK, `{"errors": [{"message": "bad group"}]}`, "bad group"},
+		{http.StatusBadRequest, `{"problem": "bad request"}`, "unexpected status 400: bad request"},
+		{http.StatusBadRequest, "<html>Bad request</html>", `unexpected status 400: body: "<html>Bad request</html>"`},
+		{http.StatusBadRequest, strings.Repeat("x", 200), fmt.Sprintf(`unexpected status 400: body: "%s..."`, strings.Repeat("x", snippetLen))},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		})
+		_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("status %d with %.20s: got error %v, want %s", tt.status, tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestFetchDecodeError(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"<html><body>Service unavailable</body></html>", `decode golangsf: invalid character '<' looking for beginning of value (body: "<html><body>Service unavailable</body></html>")`},
+		{`{"name": "GoSF", "memb`, `decode golangsf: unexpected end of JSON input (body: "{\"name\": \"GoSF\", \"memb")`},
+		{"<html>" + strings.Repeat("x", 200), fmt.Sprintf(`decode golangsf: invalid character '<' looking for beginning of value (body: "<html>%s...")`, strings.Repeat("x", snippetLen-len("<html>")))},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		})
+		_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%.20s: got error %v, want %s", tt.body, err, tt.want)
+		}
+		if kind := Kind(err); kind != ErrMeetupAPI {
+			t.Errorf("%.20s: got error kind %v, want %v", tt.body, kind, ErrMeetupAPI)
+		}
+	}
+}
+
+func TestPostGroups(t *testing.T) {
+	defer func(old int) { maxIDs = old }(maxIDs)
+	maxIDs = 2
+	tests := []struct {
+		body   string
+		status int
+		groups []string
+	}{
+		{`{"ids": ["golangsf", "golang-paris", "golangsf"]}`, http.StatusOK, []string{"golangsf", "golang-paris"}},
+		{`{"ids": ["golangsf", "golang-paris", "golang-users-berlin"]}`, http.StatusBadRequest, nil},
+		{`{"ids": []}`, http.StatusBadRequest, nil},
+		{`{"ids": ["golang/sf"]}`, http.StatusBadRequest, nil},
+		{`{"ids": "golangsf"}`, http.StatusBadRequest, nil},
+		{`{"ids": [`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		w := serve(getGroups, newRequest(t, "POST", "/api/groups", strings.NewReader(tt.body)))
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.body, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var res groupsResponse
+		decode(t, w, &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("%s: got groups %q, want %q", tt.body, got, tt.groups)
+		}
+	}
+}
+
+func TestNotFoundCached(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	responseTTL = 0
+	start := time.Now()
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+
+	for _, tt := range []struct {
+		elapsed time.Duration
+		fetches int
+	}{
+		{0, 1},
+		{notFoundTTL / 2, 1},
+		{notFoundTTL * 2, 2},
+	} {
+		clock = start.Add(tt.elapsed)
+		var res groupsResponse
+		decode(t, get(t, getGroups, "/api/groups?ids=golangsf,nope"), &res)
+		// the missing group is always reported
+		if len(res.Errors) != 1 || res.Errors[0].ID != "nope" || res.Errors[0].Kind != "not_found" {
+			t.Errorf("after %v: got errors %+v, want nope not found", tt.elapsed, res.Errors)
+		}
+		if n := f.fetches("nope"); n != tt.fetches {
+			t.Errorf("after %v: got %d fetches, want %d", tt.elapsed, n, tt.fetches)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		link, want string
+	}{
+		{"https://www.meetup.com/golangsf/", "https://www.meetup.com/golangsf/"},
+		{"www.meetup.com/golangsf/", "https://www.meetup.com/golangsf/"},
+		{"//www.meetup.com/golangsf/", "https://www.meetup.com/golangsf/"},
+		{"http://www.meetup.com/golangsf/", "https://www.meetup.com/golangsf/"},
+		{"https://www.meetup.com/golangsf/?utm_source=x&utm_medium=y&_af=z", "https://www.meetup.com/golangsf/"},
+		{"https://www.meetup.com/golangsf/?page=2&utm_campaign=x", "https://www.meetup.com/golangsf/?page=2"},
+		{"", ""},
+		{"https://%zz", "https://%zz"},
+	}
+	c := newTestContext(t)
+	for _, tt := range tests {
+		if got := normalizeURL(c, tt.link); got != tt.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	defer setKeys("a", "b", "c")()
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		key, _ := apiKey()
+		seen[key]++
+	}
+	if want := map[string]int{"a": 2, "b": 2, "c": 2}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("got keys %v, want %v", seen, want)
+	}
+
+	disableKey(newTestContext(t), "b")
+	for i := 0; i < 6; i++ {
+		if key, _ := apiKey(); key == "b" {
+			t.Fatal("got the disabled key")
+		}
+	}
+}
+
+func TestKeyRejected(t *testing.T) {
+	tests := []struct {
+		status   int
+		body     string
+		disabled bool
+	}{
+		{http.StatusUnauthorized, `{"errors": [{"code": "auth_fail", "message": "Invalid signature"}]}`, true},
+		{http.StatusForbidden, `{"errors": [{"code": "auth_fail", "message": "Invalid API key"}]}`, true},
+		{http.StatusForbidden, `{"errors": [{"code": "group_error", "message": "This group is private"}]}`, false},
+		{http.StatusInternalServerError, `{"problem": "invalid key"}`, false},
+	}
+	for _, tt := range tests {
+		restoreKeys := setKeys("a", "b")
+		// only the first key used is rejected
+		var keys []string
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			keys = append(keys, r.FormValue("key"))
+			if r.FormValue("key") != keys[0] {
+				fmt.Fprint(w, `{"name": "GoSF"}`)
+				return
+			}
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		})
+		for i := 0; i < 5; i++ {
+			fetch(newTestContext(t), "golangsf", time.Time{})
+		}
+		stop()
+		restoreKeys()
+
+		used := false
+		for _, k := range keys[1:] {
+			used = used || k == keys[0]
+		}
+		if used == tt.disabled {
+			t.Errorf("status %d with %s: got key used again %v, want %v", tt.status, tt.body, used, !tt.disabled)
+		}
+	}
+}
+
+func TestGroupStats(t *testing.T) {
+	tests := []struct {
+		groups []*Group
+		want   *Stats
+	}{
+		{nil, nil},
+		{testGroups()[:1], &Stats{Total: 100, Average: 100, Min: 100, Max: 100, MaxGroup: "golangsf"}},
+		{testGroups(), &Stats{Total: 230, Average: 230.0 / 3, Min: 50, Max: 100, MaxGroup: "golangsf"}},
+	}
+	for _, tt := range tests {
+		if got := groupStats(tt.groups); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("groupStats(%q) = %+v, want %+v", groupIDs(tt.groups), got, tt.want)
+		}
+	}
+
+	// and with no groups they're left out of the response
+	f, _, restore := setup(testGroups()[:1]...)
+	defer restore()
+	f.fail("golangsf", ErrNotFound)
+	if w := get(t, getGroups, "/api/groups"); strings.Contains(w.Body.String(), `"Stats"`) {
+		t.Errorf("got stats without groups: %s", w.Body)
+	}
+}
+
+func TestGetCountries(t *testing.T) {
+	groups := append(testGroups(),
+		&Group{ID: "bostongolang", Country: "US"},
+		&Group{ID: "golang-syd", Country: "au"},
+		&Group{ID: "Go-User-Group-Hamburg", Country: "de"},
+	)
+	tests := []struct {
+		fail string
+		want string
+	}{
+		{"", `{"apiVersion":"2","countries":[{"country":"de","count":2},{"country":"us","count":2},{"country":"au","count":1},{"country":"fr","count":1}],"errors":[]}`},
+		{"golang-syd", `{"apiVersion":"2","countries":[{"country":"de","count":2},{"country":"us","count":2},{"country":"fr","count":1}],"errors":[{"id":"golang-syd","kind":"not_found","message":"unexpected status 404"}]}`},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(groups...)
+		if tt.fail != "" {
+			f.fail(tt.fail, ErrNotFound)
+		}
+		w := get(t, getCountries, "/api/countries")
+		restore()
+		if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+			t.Errorf("got %s\nwant %s", got, tt.want)
+		}
+	}
+}
+
+func TestPretty(t *testing.T) {
+	tests := []struct {
+		h      http.HandlerFunc
+		url    string
+		pretty bool
+	}{
+		{getGroups, "/api/groups", false},
+		{getGroups, "/api/groups?pretty=1", true},
+		{getGroups, "/api/groups?features=pretty", true},
+		{getGroup, "/api/group/golangsf", false},
+		{getGroup, "/api/group/golangsf?pretty=1", true},
+		{countGroups, "/api/groups/count?cheap=1&pretty=1", true},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		w := get(t, tt.h, tt.url)
+		restore()
+		if pretty := strings.Contains(w.Body.String(), "\n  \""); pretty != tt.pretty {
+			t.Errorf("GET %s: got indented %v, want %v", tt.url, pretty, tt.pretty)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	defer setKeys("test-key")()
+	defer func(old string) { userAgent = old }(userAgent)
+	userAgent = "my-groups/2.0"
+	var got string
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+		fmt.Fprint(w, `{"name": "GoSF"}`)
+	})()
+
+	fetch(newTestContext(t), "golangsf", time.Time{})
+	if got != userAgent {
+		t.Errorf("got User-Agent %q, want %q", got, userAgent)
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	_, cache, restore := setup(testGroups()...)
+	defer restore()
+	cache.Set("golangsf", cachedGroup{Group: testGroups()[0]}, time.Hour)
+
+	tests := []struct {
+		name         string
+		hits, misses string
+	}{
+		{"first", "1", "2"},
+		// served from the response cache
+		{"second", "3", "0"},
+	}
+	for _, tt := range tests {
+		w := get(t, getGroups, "/api/groups")
+		if hits, misses := w.Header().Get("X-Cache-Hits"), w.Header().Get("X-Cache-Misses"); hits != tt.hits || misses != tt.misses {
+			t.Errorf("%s request: got %s hits and %s misses, want %s and %s", tt.name, hits, misses, tt.hits, tt.misses)
+		}
+	}
+}
+
+func TestGetGroupsMinMembers(t *testing.T) {
+	tests := []struct {
+		query  string
+		status int
+		groups []string
+	}{
+		{"minMembers=80", http.StatusOK, []string{"golang-users-berlin", "golangsf"}},
+		{"minMembers=81", http.StatusOK, []string{"golangsf"}},
+		{"minMembers=1000", http.StatusOK, []string{}},
+		{"minMembers=80&country=de", http.StatusOK, []string{"golang-users-berlin"}},
+		{"minMembers=many", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(testGroups()...)
+		f.fail("golang-paris", ErrNotFound)
+		w := get(t, getGroups, "/api/groups?"+tt.query)
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.query, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var res groupsResponse
+		decode(t, w, &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("%s: got groups %q, want %q", tt.query, got, tt.groups)
+		}
+		if len(res.Errors) != 1 {
+			t.Errorf("%s: got errors %+v, want the one of golang-paris", tt.query, res.Errors)
+		}
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	tests := []struct {
+		h    http.HandlerFunc
+		url  string
+		body bool // whether the body has the version, not just the headers
+	}{
+		{getGroups, "/api/groups", true},
+		{getGroups, "/api/groups?errors=only", true},
+		{getGroup, "/api/group/golangsf", false},
+		{countGroups, "/api/groups/count", true},
+		{getCountries, "/api/countries", true},
+		{getConfigIDs, "/api/config/ids", true},
+	}
+	for _, tt := range tests {
+		w := get(t, tt.h, tt.url)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", tt.url, w.Code, http.StatusOK)
+			continue
+		}
+		if got := w.Header().Get("X-API-Version"); got != apiVersion {
+			t.Errorf("%s: got X-API-Version %q, want %q", tt.url, got, apiVersion)
+		}
+		if !tt.body {
+			continue
+		}
+		var res struct {
+			APIVersion *string `json:"apiVersion"`
+		}
+		decode(t, w, &res)
+		if res.APIVersion == nil || *res.APIVersion != apiVersion {
+			t.Errorf("%s: got no apiVersion %q in %s", tt.url, apiVersion, w.Body)
+		}
+	}
+}
+
+// orderFetcher is a Fetcher recording the order of the fetches, and how
+// many of them were in progress at most.
+type orderFetcher struct {
+	Fetcher
+	mu      sync.Mutex
+	order   []string
+	running int
+	max     int
+}
+
+func (f *orderFetcher) Fetch(c appengine.Context, id string) (*Group, error) {
+	f.mu.Lock()
+	f.order = append(f.order, id)
+	f.running++
+	if f.running > f.max {
+		f.max = f.running
+	}
+	f.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	defer func() {
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+	}()
+	return f.Fetcher.Fetch(c, id)
+}
+
+func TestGetGroupsSequential(t *testing.T) {
+	groups := testGroups()
+	groups = append(groups, &Group{ID: "golang-amsterdam", Name: "Go Amsterdam", Members: 70})
+	want := groupIDs(groups)
+	tests := []struct {
+		name       string
+		sequential bool
+		url        string
+	}{
+		{"parameter", false, "/api/groups?sequential=1"},
+		{"environment", true, "/api/groups"},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(groups...)
+		of := &orderFetcher{Fetcher: f}
+		fetcher = of
+		sequentialFetch = tt.sequential
+		w := get(t, getGroups, tt.url)
+		sequentialFetch = false
+		restore()
+
+		var res groupsResponse
+		decode(t, w, &res)
+		if got := groupIDs(res.Groups); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got groups %q, want them in the configured order %q", tt.name, got, want)
+		}
+		if !reflect.DeepEqual(of.order, want) || of.max != 1 {
+			t.Errorf("%s: fetched %q with up to %d at a time, want %q one at a time", tt.name, of.order, of.max, want)
+		}
+	}
+
+	// sorting them is still possible
+	_, _, restore := setup(groups...)
+	defer restore()
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups?sequential=1&sort=-members"), &res)
+	if got, want := groupIDs(res.Groups), []string{"golangsf", "golang-users-berlin", "golang-amsterdam", "golang-paris"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted by members: got groups %q, want %q", got, want)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		kind   string
+	}{
+		{"not found", http.StatusNotFound, `{"errors": [{"code": "group_error", "message": "Invalid group urlname"}]}`, "not_found"},
+		{"server error", http.StatusInternalServerError, "oops", "meetup"},
+		{"error payload", http.StatusOK, `{"errors": [{"message": "bad group"}]}`, "meetup"},
+		{"HTML page", http.StatusOK, "<html>Service unavailable</html>", "meetup"},
+		{"rate limited", http.StatusTooManyRequests, "", "rate_limited"},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			fmt.Fprint(w, tt.body)
+		})
+		_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err == nil {
+			t.Errorf("%s: got no error, want a %s one", tt.name, tt.kind)
+			continue
+		}
+		if e := newFetchError("golangsf", err); e.Kind != tt.kind || e.ID != "golangsf" || e.Message != err.Error() {
+			t.Errorf("%s: got %+v, want kind %s", tt.name, e, tt.kind)
+		}
+	}
+
+	// meetup can't be reached once its server is closed
+	defer meetupServer(nil)()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	apiBaseURL = srv.URL
+	_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+	if e := newFetchError("golangsf", err); err == nil || e.Kind != "network" {
+		t.Errorf("unreachable meetup: got %+v, want kind network", e)
+	}
+
+	// and the errors that are not from meetup
+	for _, tt := range []struct {
+		err  error
+		kind string
+	}{
+		{errTimeout, "timeout"},
+		{errors.New("boom"), "internal"},
+	} {
+		if e := newFetchError("golangsf", tt.err); e.Kind != tt.kind {
+			t.Errorf("%v: got kind %s, want %s", tt.err, e.Kind, tt.kind)
+		}
+	}
+}
+
+func TestCacheJitter(t *testing.T) {
+	_, cache, restore := setup(testGroups()...)
+	defer restore()
+	defer func(old float64) { cacheJitter = old }(cacheJitter)
+	cacheJitter = 0.2
+	start := time.Now()
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return start }
+
+	values := []float64{0, 0.25, 0.5, 0.999}
+	i := 0
+	random = func() float64 {
+		v := values[i%len(values)]
+		i++
+		return v
+	}
+	c := newTestContext(t)
+	min, max := start.Add(48*time.Minute), start.Add(72*time.Minute)
+	seen := make(map[time.Time]bool)
+	for range values {
+		store(c, cache, "golangsf", testGroups()[0])
+		expires := cache.items["golangsf"].expires
+		if expires.Before(min) || expires.After(max) {
+			t.Errorf("expires in %v, want between 48m and 1h12m", expires.Sub(start))
+		}
+		seen[expires] = true
+	}
+	if len(seen) != len(values) {
+		t.Errorf("got %d different expirations, want %d", len(seen), len(values))
+	}
+
+	// with no jitter they all expire together
+	cacheJitter = 0
+	if ttl := jitter(time.Hour); ttl != time.Hour {
+		t.Errorf("jitter(1h) with no jitter = %v, want 1h", ttl)
+	}
+}
+
+// clockFetcher is a Fetcher advancing the fake clock by d on every fetch.
+type clockFetcher struct {
+	Fetcher
+	mu    *sync.Mutex
+	clock *time.Time
+	d     time.Duration
+}
+
+func (f clockFetcher) Fetch(c appengine.Context, id string) (*Group, error) {
+	f.mu.Lock()
+	*f.clock = f.clock.Add(f.d)
+	f.mu.Unlock()
+	return f.Fetcher.Fetch(c, id)
+}
+
+func TestResponseTime(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	var mu sync.Mutex
+	clock := time.Now()
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	fetcher = clockFetcher{f, &mu, &clock, 137 * time.Millisecond}
+
+	w := get(t, getGroups, "/api/groups")
+	if got := w.Header().Get("X-Response-Time"); got != "411ms" {
+		t.Errorf("got X-Response-Time %q, want 411ms", got)
+	}
+
+	// the cached response takes no time
+	w = get(t, getGroups, "/api/groups")
+	if got := w.Header().Get("X-Response-Time"); got != "0ms" {
+		t.Errorf("cached response: got X-Response-Time %q, want 0ms", got)
+	}
+}
+
+func TestSortErrors(t *testing.T) {
+	errs := []fetchError{
+		{"golangsf", "network", "boom"},
+		{"golang-paris", "timeout", "timeout"},
+		{"golangsf", "meetup", "bad group"},
+		{"golangsf", "network", "boom"},
+		{"golang-paris", "network", "boom"},
+	}
+	want := []fetchError{
+		{"golang-paris", "network", "boom"},
+		{"golang-paris", "timeout", "timeout"},
+		{"golangsf", "meetup", "bad group"},
+		{"golangsf", "network", "boom"},
+	}
+	if got := sortErrors(errs); !reflect.DeepEqual(got, want) {
+		t.Errorf("sortErrors = %+v, want %+v", got, want)
+	}
+	if got := sortErrors(nil); got == nil || len(got) != 0 {
+		t.Errorf("sortErrors(nil) = %#v, want an empty list", got)
+	}
+
+	// the handler lists them in the same order every time
+	var bodies []string
+	for i := 0; i < 5; i++ {
+		f, _, restore := setup(testGroups()...)
+		f.fail("golangsf", &kindError{ErrNetwork, errors.New("boom")})
+		f.fail("golang-users-berlin", ErrRateLimited)
+		f.fail("golang-paris", ErrNotFound)
+		w := get(t, getGroups, "/api/groups?errors=only")
+		restore()
+		bodies = append(bodies, w.Body.String())
+	}
+	for _, body := range bodies[1:] {
+		if body != bodies[0] {
+			t.Fatalf("got the errors %s, then %s", bodies[0], body)
+		}
+	}
+	var res struct{ Errors []fetchError }
+	if err := json.Unmarshal([]byte(bodies[0]), &res); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Errors, []fetchError{
+		{"golang-paris", "not_found", ErrNotFound.Error()},
+		{"golang-users-berlin", "rate_limited", ErrRateLimited.Error()},
+		{"golangsf", "network", "boom"},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got errors %+v, want %+v", got, want)
+	}
+}
+
+func TestGetGroupsErrorsMode(t *testing.T) {
+	tests := []struct {
+		query   string
+		fail    bool     // whether golang-paris fails to fetch
+		present []string // the top level fields of the response
+		errors  string   // the encoded errors, if any
+	}{
+		{"", false, []string{"Groups", "Errors"}, "[]"},
+		{"", true, []string{"Groups", "Errors"}, `[{"id":"golang-paris","kind":"not_found","message":"unexpected status 404"}]`},
+		{"errors=omit", false, []string{"Groups"}, ""},
+		{"errors=omit", true, []string{"Groups"}, ""},
+		{"errors=omit&fields=ID", true, []string{"Groups"}, ""},
+		{"fields=ID", false, []string{"Groups", "Errors"}, "[]"},
+		{"errors=only", false, []string{"Errors"}, "[]"},
+		{"errors=only", true, []string{"Errors"}, `[{"id":"golang-paris","kind":"not_found","message":"unexpected status 404"}]`},
+	}
+	for _, tt := range tests {
+		f, _, restore := setup(testGroups()...)
+		if tt.fail {
+			f.fail("golang-paris", ErrNotFound)
+		}
+		w := get(t, getGroups, "/api/groups?"+tt.query)
+		restore()
+
+		var res map[string]json.RawMessage
+		decode(t, w, &res)
+		for _, name := range []string{"Groups", "Errors"} {
+			_, ok := res[name]
+			want := false
+			for _, p := range tt.present {
+				want = want || p == name
+			}
+			if ok != want {
+				t.Errorf("%q with errors %v: got %s present %v, want %v", tt.query, tt.fail, name, ok, want)
+			}
+		}
+		if got := string(res["Errors"]); got != tt.errors {
+			t.Errorf("%q with errors %v: got errors %s, want %s", tt.query, tt.fail, got, tt.errors)
+		}
+	}
+
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	if w := get(t, getGroups, "/api/groups?errors=all"); w.Code != http.StatusBadRequest {
+		t.Errorf("errors=all: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// setFetchSlots sets the number of requests to meetup that can be in progress,
+// and returns a function restoring the previous one.
+func setFetchSlots(n int) func() {
+	old := fetchSlots
+	fetchSlots = make(chan struct{}, n)
+	return func() { fetchSlots = old }
+}
+
+func TestFetchSlots(t *testing.T) {
+	defer setKeys("test-key")()
+	defer setFetchSlots(2)()
+	var mu sync.Mutex
+	running, max := 0, 0
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		fmt.Fprint(w, `{"name": "GoSF"}`)
+	})()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("fetch: %v", err)
+		}
+	}
+	if max > 2 {
+		t.Errorf("got up to %d requests to meetup in progress, want 2 at most", max)
+	}
+}
+
+func TestFetchBusy(t *testing.T) {
+	defer setKeys("test-key")()
+	defer setFetchSlots(1)()
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "GoSF"}`)
+	})()
+	defer func(old time.Duration) { requestTimeout = old }(requestTimeout)
+	requestTimeout = 20 * time.Millisecond
+
+	// the fetch waits until the request deadline for the slot in use
+	fetchSlots <- struct{}{}
+	start := time.Now()
+	_, err := fetch(newTestContext(t), "golangsf", time.Time{})
+	if err != errBusy {
+		t.Fatalf("fetch with no free slot: got %v, want %v", err, errBusy)
+	}
+	if d := time.Since(start); d < requestTimeout || d > time.Second {
+		t.Errorf("fetch with no free slot returned after %v, want %v", d, requestTimeout)
+	}
+	if e := newFetchError("golangsf", err); e.Kind != "busy" {
+		t.Errorf("got kind %s, want busy", e.Kind)
+	}
+
+	// and gets it once released
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		<-fetchSlots
+	}()
+	if _, err := fetch(newTestContext(t), "golangsf", time.Time{}); err != nil {
+		t.Errorf("fetch once the slot is released: %v", err)
+	}
+	if n := len(fetchSlots); n != 0 {
+		t.Errorf("%d slots in use after the fetch, want none", n)
+	}
+}
+
+func TestFetchSlotsRetry(t *testing.T) {
+	defer setKeys("test-key")()
+	defer setFetchSlots(1)()
+	fail := true
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"name": "GoSF"}`)
+	})()
+
+	// the slot is free while waiting to retry
+	slept := false
+	sleep = func(time.Duration) {
+		slept = true
+		if n := len(fetchSlots); n != 0 {
+			t.Errorf("%d slots in use while waiting to retry, want none", n)
+		}
+	}
+	if _, err := fetch(newTestContext(t), "golangsf", time.Time{}); err != nil || !slept {
+		t.Errorf("fetch after a server error: got %v and retried %v, want no error after a retry", err, slept)
+	}
+}
+
+func TestGroupPhoto(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		body  string
+		photo string
+	}{
+		{`{"name": "GoSF", "group_photo": {"highres_link": "https://photos/high.jpg", "thumb_link": "https://photos/thumb.jpg"}}`, "https://photos/high.jpg"},
+		{`{"name": "GoSF", "group_photo": {"thumb_link": "https://photos/thumb.jpg"}}`, "https://photos/thumb.jpg"},
+		{`{"name": "GoSF", "group_photo": {"highres_link": "", "thumb_link": "https://photos/thumb.jpg"}}`, "https://photos/thumb.jpg"},
+		{`{"name": "GoSF", "group_photo": null}`, ""},
+		{`{"name": "GoSF"}`, ""},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		})
+		g, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if g.PhotoURL != tt.photo {
+			t.Errorf("%s: got photo %q, want %q", tt.body, g.PhotoURL, tt.photo)
+		}
+
+		// it's listed and cached too
+		_, cache, restore := setup(g)
+		var res groupsResponse
+		decode(t, get(t, getGroups, "/api/groups"), &res)
+		var cg cachedGroup
+		cache.Get("golangsf", &cg)
+		restore()
+		if len(res.Groups) != 1 || res.Groups[0].PhotoURL != tt.photo {
+			t.Errorf("%s: got groups %+v, want one with the photo %q", tt.body, res.Groups, tt.photo)
+		}
+		if cg.Group == nil || cg.Group.PhotoURL != tt.photo {
+			t.Errorf("%s: got %+v cached, want the photo %q", tt.body, cg.Group, tt.photo)
+		}
+	}
+}
+
+func TestOrganizer(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		body      string
+		organizer string
+	}{
+		{`{"name": "GoSF", "organizer": {"id": 42, "name": "Gopher"}}`, "Gopher"},
+		{`{"name": "GoSF", "organizer": {"id": 42}}`, ""},
+		{`{"name": "GoSF", "organizer": null}`, ""},
+		{`{"name": "GoSF"}`, ""},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		})
+		g, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if g.Organizer != tt.organizer {
+			t.Errorf("%s: got organizer %q, want %q", tt.body, g.Organizer, tt.organizer)
+		}
+
+		// it's listed and cached too
+		_, cache, restore := setup(g)
+		w := get(t, getGroups, "/api/groups")
+		var cg cachedGroup
+		cache.Get("golangsf", &cg)
+		restore()
+		var res struct{ Groups []map[string]interface{} }
+		decode(t, w, &res)
+		if organizer, ok := res.Groups[0]["Organizer"]; (tt.organizer == "") == ok || ok && organizer != tt.organizer {
+			t.Errorf("%s: got %s, want the organizer %q", tt.body, w.Body, tt.organizer)
+		}
+		if cg.Group == nil || cg.Group.Organizer != tt.organizer {
+			t.Errorf("%s: got %+v cached, want the organizer %q", tt.body, cg.Group, tt.organizer)
+		}
+	}
+}
+
+func TestGetGroupsTooLarge(t *testing.T) {
+	var groups []*Group
+	for i := 0; i < 200; i++ {
+		groups = append(groups, &Group{
+			ID:      fmt.Sprintf("golang-%03d", i),
+			Name:    fmt.Sprintf("Go meetup %03d %s", i, strings.Repeat("x", 100)),
+			Members: i,
+		})
+	}
+	f, _, restore := setup(groups...)
+	defer restore()
+	defer func(old int) { maxResponseSize = old }(maxResponseSize)
+	maxResponseSize = 10000
+	f.fail("golang-000", &kindError{ErrNetwork, errors.New("boom")})
+
+	w := get(t, getGroups, "/api/groups")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	var res errorResponse
+	decode(t, w, &res)
+	if !strings.Contains(res.Error, "10000 bytes") || !strings.Contains(res.Error, "limit and offset") {
+		t.Errorf("got error %q, want one asking for pages", res.Error)
+	}
+	if n := w.Header().Get("X-Fetch-Errors"); n != "" {
+		t.Errorf("got X-Fetch-Errors %q on the error", n)
+	}
+
+	// a page of them fits
+	w = get(t, getGroups, "/api/groups?limit=10")
+	if w.Code != http.StatusOK {
+		t.Errorf("with limit=10: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestNextEvent(t *testing.T) {
+	defer setKeys("test-key")()
+	next := time.Date(2012, 3, 1, 18, 30, 0, 0, time.UTC)
+	tests := []struct {
+		body string
+		name string
+		time *time.Time
+	}{
+		{`{"name": "GoSF", "next_event": {"name": "Go 1 launch", "time": 1330626600000}}`, "Go 1 launch", &next},
+		{`{"name": "GoSF", "next_event": {"name": "Go 1 launch"}}`, "Go 1 launch", nil},
+		{`{"name": "GoSF", "next_event": null}`, "", nil},
+		{`{"name": "GoSF"}`, "", nil},
+	}
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		})
+		g, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if g.NextEventName != tt.name || !reflect.DeepEqual(g.NextEventTime, tt.time) {
+			t.Errorf("%s: got next event %q at %v, want %q at %v", tt.body, g.NextEventName, g.NextEventTime, tt.name, tt.time)
+		}
+
+		// it's listed and cached too, and left out when there's none
+		_, cache, restore := setup(g)
+		w := get(t, getGroups, "/api/groups")
+		var cg cachedGroup
+		cache.Get("golangsf", &cg)
+		restore()
+		var res struct{ Groups []map[string]interface{} }
+		decode(t, w, &res)
+		if len(res.Groups) != 1 {
+			t.Errorf("%s: got groups %v, want one", tt.body, res.Groups)
+			continue
+		}
+		got, ok := res.Groups[0]["NextEventTime"]
+		switch {
+		case tt.time == nil && ok:
+			t.Errorf("%s: got NextEventTime %v listed, want none", tt.body, got)
+		case tt.time != nil && got != "2012-03-01T18:30:00Z":
+			t.Errorf("%s: got NextEventTime %v listed, want %v", tt.body, got, next.Format(time.RFC3339))
+		}
+		if cg.Group == nil || cg.Group.NextEventName != tt.name || !reflect.DeepEqual(cg.Group.NextEventTime, tt.time) {
+			t.Errorf("%s: got %+v cached, want the next event %q at %v", tt.body, cg.Group, tt.name, tt.time)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+)
+
+func init() {
+	memcacheTimeout = durationEnv("MEMCACHE_TIMEOUT", memcacheTimeout)
+}
+
+// memcacheTimeout is the longest a memcache call can take, so a slow memcache
+// doesn't slow down the requests more than a fetch would. It can be
+// overridden with MEMCACHE_TIMEOUT.
+var memcacheTimeout = 200 * time.Millisecond
+
+// withTimeout is appengine.Timeout, tests replace it to check the deadlines.
+var withTimeout = appengine.Timeout
+
+// memcacheContext returns the context to use for memcache calls, with a
+// deadline of memcacheTimeout, or earlier if the request of c is due earlier.
+// It returns false once the request is past its deadline, the call is skipped
+// then.
+func memcacheContext(c appengine.Context) (appengine.Context, bool) {
+	d := memcacheTimeout
+	if left := requestDeadline(c).Sub(now()); left < d {
+		d = left
+	}
+	if d <= 0 {
+		return nil, false
+	}
+	return withTimeout(c, d), true
+}
+
+const (
+	// breakerThreshold is the number of memcache failures in a row after
+	// which we stop using it.
+	breakerThreshold = 5
+	// breakerCooldown is how long we stop using memcache for.
+	breakerCooldown = 30 * time.Second
+)
+
+// breaker is a circuit breaker for memcache: when it keeps failing we skip it
+// for a while instead of paying for the failed calls and logging them.
+var breaker = struct {
+	sync.Mutex
+	failures  int
+	openUntil time.Time
+}{}
+
+// memcacheAvailable reports whether memcache should be used, which is not the
+// case while the breaker is open.
+func memcacheAvailable() bool {
+	breaker.Lock()
+	defer breaker.Unlock()
+	return !now().Before(breaker.openUntil)
+}
+
+// memcacheResult records the error returned by a memcache call, a cache miss
+// is not a failure.
+func memcacheResult(c appengine.Context, err error) {
+	breaker.Lock()
+	defer breaker.Unlock()
+	if err == nil || err == memcache.ErrCacheMiss {
+		breaker.failures = 0
+		return
+	}
+	if appengine.IsTimeoutError(err) {
+		c.Warningf("memcache timed out after %v", memcacheTimeout)
+	}
+	breaker.failures++
+	if breaker.failures >= breakerThreshold {
+		c.Warningf("memcache failed %d times in a row, skipping it for %v", breaker.failures, breakerCooldown)
+		breaker.failures = 0
+		breaker.openUntil = now().Add(breakerCooldown)
+	}
+}
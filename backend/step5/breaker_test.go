@@ -0,0 +1,192 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"appengine"
+)
+
+// logContext is an appengine.Context recording the infos, warnings and
+// errors logged.
+type logContext struct {
+	appengine.Context
+	mu   *sync.Mutex
+	logs *[]string
+}
+
+func newLogContext(c appengine.Context) logContext {
+	return logContext{c, &sync.Mutex{}, new([]string)}
+}
+
+func (c logContext) Infof(format string, args ...interface{}) {
+	c.record("info: " + fmt.Sprintf(format, args...))
+}
+
+func (c logContext) Warningf(format string, args ...interface{}) {
+	c.record("warning: " + fmt.Sprintf(format, args...))
+}
+
+func (c logContext) Errorf(format string, args ...interface{}) {
+	c.record("error: " + fmt.Sprintf(format, args...))
+}
+
+func (c logContext) record(line string) {
+	c.mu.Lock()
+	*c.logs = append(*c.logs, line)
+	c.mu.Unlock()
+}
+
+// lines returns the lines logged so far.
+func (c logContext) lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), *c.logs...)
+}
+
+func TestBreaker(t *testing.T) {
+	_, _, restore := setup()
+	defer restore()
+	start := time.Now()
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+	c := newLogContext(newTestContext(t))
+	cache := memcacheCache{c}
+	if err := cache.Set("breaker-test", "cached", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failure short of the threshold doesn't open it, and a success
+	// starts counting again
+	fail := errors.New("memcache down")
+	for i := 0; i < breakerThreshold-1; i++ {
+		memcacheResult(c, fail)
+	}
+	memcacheResult(c, nil)
+	memcacheResult(c, fail)
+	if !memcacheAvailable() {
+		t.Fatal("breaker open before the threshold")
+	}
+
+	// it opens after breakerThreshold failures in a row
+	for i := 0; i < breakerThreshold-1; i++ {
+		memcacheResult(c, fail)
+	}
+	if memcacheAvailable() {
+		t.Fatal("breaker closed after the threshold")
+	}
+	if logs := c.lines(); len(logs) != 1 {
+		t.Errorf("got logs %q, want a single warning", logs)
+	}
+
+	// memcache is not called during the cooldown
+	var v string
+	if err := cache.Get("breaker-test", &v); err != ErrCacheMiss {
+		t.Errorf("cache get while open: got %q, %v; want a miss", v, err)
+	}
+	if err := cache.Set("breaker-test", "other", time.Hour); err != nil {
+		t.Errorf("cache set while open: %v", err)
+	}
+
+	clock = clock.Add(breakerCooldown + time.Second)
+	if !memcacheAvailable() {
+		t.Fatal("breaker open after the cooldown")
+	}
+	if err := cache.Get("breaker-test", &v); err != nil || v != "cached" {
+		t.Errorf("cache get after the cooldown: got %q, %v; want %q", v, err, "cached")
+	}
+}
+
+// timeoutError is an error like the ones of the App Engine API calls that
+// ran past their deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "API call timed out" }
+func (timeoutError) IsTimeout() bool { return true }
+
+func TestMemcacheTimeout(t *testing.T) {
+	_, _, restore := setup()
+	defer restore()
+	c := newLogContext(newTestContext(t))
+
+	// the timeouts are logged apart from the other failures, and count
+	// towards opening the breaker
+	memcacheResult(c, errors.New("memcache down"))
+	memcacheResult(c, timeoutError{})
+	want := []string{fmt.Sprintf("warning: memcache timed out after %v", memcacheTimeout)}
+	if got := c.lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got logs %q, want %q", got, want)
+	}
+	for i := 2; i < breakerThreshold; i++ {
+		memcacheResult(c, timeoutError{})
+	}
+	if memcacheAvailable() {
+		t.Error("breaker closed after timing out breakerThreshold times")
+	}
+}
+
+func TestMemcacheDeadline(t *testing.T) {
+	_, _, restore := setup()
+	defer restore()
+	start := time.Now()
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+	defer func(old time.Duration) { memcacheTimeout = old }(memcacheTimeout)
+	memcacheTimeout = 200 * time.Millisecond
+	// a slow memcache, every call takes 150ms of the request
+	var timeouts []time.Duration
+	defer func(old func(appengine.Context, time.Duration) appengine.Context) { withTimeout = old }(withTimeout)
+	withTimeout = func(c appengine.Context, d time.Duration) appengine.Context {
+		timeouts = append(timeouts, d)
+		clock = clock.Add(150 * time.Millisecond)
+		return c
+	}
+
+	// the calls get memcacheTimeout while the request has time for it, and
+	// what's left of it after
+	cache := memcacheCache{requestContext{newTestContext(t), "req-1", start.Add(400 * time.Millisecond)}}
+	var v string
+	if err := cache.Set("deadline-test", "cached", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cache.Get("deadline-test", &v); err != nil || v != "cached" {
+			t.Errorf("get %d: got %q, %v; want %q", i, v, err, "cached")
+		}
+	}
+	want := []time.Duration{200 * time.Millisecond, 200 * time.Millisecond, 100 * time.Millisecond}
+	if !reflect.DeepEqual(timeouts, want) {
+		t.Errorf("got timeouts %v, want %v", timeouts, want)
+	}
+
+	// once the request is out of time memcache is not called at all
+	timeouts = nil
+	if err := cache.Get("deadline-test", &v); err != ErrCacheMiss {
+		t.Errorf("get past the deadline: got %v, want a miss", err)
+	}
+	if err := cache.Set("deadline-test", "late", time.Minute); err != nil {
+		t.Errorf("set past the deadline: %v", err)
+	}
+	if err := cache.Delete("deadline-test"); err != errCacheUnavailable {
+		t.Errorf("delete past the deadline: got %v, want %v", err, errCacheUnavailable)
+	}
+	if timeouts != nil {
+		t.Errorf("got calls with timeouts %v past the deadline, want none", timeouts)
+	}
+	if !memcacheAvailable() {
+		t.Error("the skipped calls opened the breaker")
+	}
+	if err := (memcacheCache{newTestContext(t)}).Get("deadline-test", &v); err != nil || v != "cached" {
+		t.Errorf("get from another request: got %q, %v; want %q", v, err, "cached")
+	}
+}
@@ -0,0 +1,182 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"appengine"
+)
+
+func init() {
+	handle("/api/cache/flush", adminOnly(flushCache))
+	handle("/api/cache/warm", adminOnly(warmCache))
+	// App Engine always sends warmup requests to this path, so it's left
+	// out of RegisterHandlers, and only App Engine can send them
+	http.HandleFunc("/_ah/warmup", warmCache)
+}
+
+// flushCache removes the cached group given in the id parameter, or every
+// configured group if there is none, replying with the number of removed keys.
+// Only the administrators can flush the cache.
+func flushCache(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "flush the cache with a POST request")
+		return
+	}
+
+	keys := ids
+	if id := r.FormValue("id"); id != "" {
+		if !validID(id) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid id %q", id))
+			return
+		}
+		keys = []string{id}
+	}
+
+	cache := newCache(c)
+	removed, err := deleteKeys(cache, keys)
+	if err != nil {
+		c.Errorf("flush cache: %v", err)
+		writeError(w, http.StatusInternalServerError, "cache delete failed")
+		return
+	}
+
+	// the cached list of groups is now outdated too
+	if err := cache.Delete(allGroupsKey(ids)); err != nil && err != ErrCacheMiss {
+		c.Errorf("cache delete %q: %v", allGroupsKey(ids), err)
+	}
+	flushResponses()
+
+	w.Header().Set("Content-Type", "application/json")
+	err = newEncoder(w, r).Encode(struct {
+		Removed int `json:"removed"`
+	}{removed})
+	if err != nil {
+		c.Errorf("encode response: %v", err)
+	}
+}
+
+// warmCache loads every configured group so they're cached before the first
+// user asks for them, replying with how many were loaded and the errors. It
+// also handles the App Engine warmup requests, sent when an instance starts.
+// Otherwise only the administrators can warm the cache.
+func warmCache(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	if r.Method != "POST" && r.URL.Path != "/_ah/warmup" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "warm the cache with a POST request")
+		return
+	}
+
+	if !hasAPIKey() {
+		writeError(w, http.StatusInternalServerError, errNoAPIKey.Error())
+		c.Errorf("warm cache: %v", errNoAPIKey)
+		return
+	}
+
+	res := struct {
+		APIVersion string       `json:"apiVersion"`
+		Loaded     int          `json:"loaded"`
+		Errors     []fetchError `json:"errors"`
+	}{APIVersion: apiVersion}
+
+	var groups []*Group
+	done := r.Context().Done()
+	ok := collect(c, ids, fetchAll(c, newCache(c), ids, false, done), done, func(p partial) {
+		if p.err != nil {
+			res.Errors = append(res.Errors, newFetchError(p.id, p.err))
+			return
+		}
+		res.Loaded++
+		groups = append(groups, p.group)
+	})
+	if !ok {
+		return
+	}
+	res.Errors = sortErrors(res.Errors)
+	if len(res.Errors) == 0 {
+		storeAll(c, ids, groups)
+	}
+	c.Infof("warm cache: loaded=%d errors=%d", res.Loaded, len(res.Errors))
+	writeJSON(c, w, r, res)
+}
+
+// deleteKeys deletes the given keys from the cache and returns how many of
+// them were actually there.
+func deleteKeys(cache Cache, keys []string) (int, error) {
+	err := cache.DeleteMulti(keys)
+	if err == nil {
+		return len(keys), nil
+	}
+	errs, ok := err.(appengine.MultiError)
+	if !ok {
+		return 0, err
+	}
+	removed := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			removed++
+		case ErrCacheMiss:
+		default:
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// allGroupsTTL is how long the list of all the groups is cached, so most
+// requests need a single cache call.
+const allGroupsTTL = time.Minute
+
+// allGroupsKey returns the cache key of the list of the groups with the given
+// ids, which depends on them so the list is not served once they change.
+func allGroupsKey(ids []string) string {
+	return fmt.Sprintf("all-groups:%x", sha1.Sum([]byte(strings.Join(ids, ","))))
+}
+
+// loadAll returns the cached list of the groups with the given ids, if any.
+func loadAll(c appengine.Context, ids []string) ([]*Group, bool) {
+	if cacheTTL <= 0 {
+		return nil, false
+	}
+
+	key := allGroupsKey(ids)
+	var groups []*Group
+	if err := newCache(c).Get(key, &groups); err != nil {
+		if err != ErrCacheMiss {
+			c.Errorf("cache get %q: %v", key, err)
+		}
+		return nil, false
+	}
+	return groups, true
+}
+
+// storeAll caches the list of the groups with the given ids, unless any of
+// them is a stale copy.
+func storeAll(c appengine.Context, ids []string, groups []*Group) {
+	if cacheTTL <= 0 {
+		return
+	}
+	for _, g := range groups {
+		if g.Stale {
+			return
+		}
+	}
+
+	key := allGroupsKey(ids)
+	if err := newCache(c).Set(key, groups, allGroupsTTL); err != nil {
+		c.Errorf("cache set %q: %v", key, err)
+	}
+}
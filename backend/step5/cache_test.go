@@ -0,0 +1,162 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"appengine/aetest"
+	"appengine/user"
+)
+
+// asAdmin logs in the request as an administrator.
+func asAdmin(r *http.Request) *http.Request {
+	aetest.Login(&user.User{Email: "admin@example.com", Admin: true}, r)
+	return r
+}
+
+func TestFlushCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		admin   bool
+		status  int
+		removed int
+	}{
+		{"all", "POST", "/api/cache/flush", true, http.StatusOK, 3},
+		{"one", "POST", "/api/cache/flush?id=golangsf", true, http.StatusOK, 1},
+		{"not cached", "POST", "/api/cache/flush?id=nope", true, http.StatusOK, 0},
+		{"invalid id", "POST", "/api/cache/flush?id=golang%2Fsf", true, http.StatusBadRequest, 0},
+		{"GET", "GET", "/api/cache/flush", true, http.StatusMethodNotAllowed, 0},
+		{"not admin", "POST", "/api/cache/flush", false, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		_, cache, restore := setup(testGroups()...)
+		for _, id := range ids {
+			cache.Set(id, cachedGroup{Group: &Group{ID: id}}, time.Hour)
+		}
+		r := newRequest(t, tt.method, tt.url, nil)
+		if tt.admin {
+			asAdmin(r)
+		}
+		w := serve(adminOnly(flushCache), r)
+		restore()
+
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var res struct{ Removed int }
+		decode(t, w, &res)
+		if res.Removed != tt.removed {
+			t.Errorf("%s: removed %d keys, want %d", tt.name, res.Removed, tt.removed)
+		}
+	}
+}
+
+func TestAllGroupsCache(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	responseTTL = 0
+	c := newTestContext(t)
+
+	// a miss stores the list
+	if _, ok := loadAll(c, ids); ok {
+		t.Fatal("loadAll found a list before any request")
+	}
+	get(t, getGroups, "/api/groups")
+	groups, ok := loadAll(c, ids)
+	if !ok || len(groups) != 3 {
+		t.Fatalf("loadAll after a request: got %d groups, %v; want 3, true", len(groups), ok)
+	}
+
+	// which is served even without the groups themselves
+	deleteKeys(cache, ids)
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups"), &res)
+	if len(res.Groups) != 3 || f.fetches("golangsf") != 1 {
+		t.Errorf("got %d groups after %d fetches, want 3 after 1", len(res.Groups), f.fetches("golangsf"))
+	}
+
+	// but not for other ids
+	if _, ok := loadAll(c, ids[:2]); ok {
+		t.Error("loadAll found a list for other ids")
+	}
+}
+
+func TestStoreAllStale(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	c := newTestContext(t)
+
+	groups := testGroups()
+	groups[1].Stale = true
+	storeAll(c, ids, groups)
+	if _, ok := loadAll(c, ids); ok {
+		t.Error("a list with a stale group was cached")
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header string // the header set in the request, if any
+		status int
+	}{
+		{"admin", "POST", "/api/cache/warm", "admin", http.StatusOK},
+		{"cron", "POST", "/api/cache/warm", "X-Appengine-Cron", http.StatusOK},
+		{"not admin", "POST", "/api/cache/warm", "", http.StatusForbidden},
+		{"GET", "GET", "/api/cache/warm", "admin", http.StatusMethodNotAllowed},
+		{"warmup request", "GET", "/_ah/warmup", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		f, cache, restore := setup(testGroups()...)
+		f.fail("golang-paris", ErrNotFound)
+		r := newRequest(t, tt.method, tt.url, nil)
+		switch tt.header {
+		case "admin":
+			asAdmin(r)
+		case "X-Appengine-Cron":
+			r.Header.Set(tt.header, "true")
+		}
+		// served by the registered handlers
+		w := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(w, r)
+		restore()
+
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		var cg cachedGroup
+		cached := cache.Get("golangsf", &cg) == nil
+		if tt.status != http.StatusOK {
+			if cached {
+				t.Errorf("%s: the groups were cached", tt.name)
+			}
+			continue
+		}
+		var res struct {
+			Loaded int
+			Errors []fetchError
+		}
+		decode(t, w, &res)
+		if res.Loaded != 2 || len(res.Errors) != 1 || res.Errors[0].ID != "golang-paris" {
+			t.Errorf("%s: got %s, want 2 loaded and the error of golang-paris", tt.name, w.Body)
+		}
+		if !cached || cg.Group.Name != "GoSF" {
+			t.Errorf("%s: golangsf was not cached", tt.name)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+)
+
+func init() {
+	if os.Getenv("CACHE_BACKEND") == "memory" {
+		newCache = func(appengine.Context) Cache { return sharedMemoryCache }
+	}
+}
+
+// Cache stores the fetched groups between requests, values are encoded as
+// JSON.
+type Cache interface {
+	// Get decodes the value cached with the given key into v, or returns
+	// ErrCacheMiss if there's none.
+	Get(key string, v interface{}) error
+	// Set caches v with the given key for ttl.
+	Set(key string, v interface{}, ttl time.Duration) error
+	// Add is like Set, but returns ErrNotStored if a value is already
+	// cached with the key.
+	Add(key string, v interface{}, ttl time.Duration) error
+	// Delete removes the value cached with the given key, or returns
+	// ErrCacheMiss if there's none.
+	Delete(key string) error
+
+	// GetMulti is like Get for several keys at once, decoding the value of
+	// keys[i] into vs[i]. If any of them fails it returns an
+	// appengine.MultiError with the error of every key, ErrCacheMiss for
+	// the ones not found.
+	GetMulti(keys []string, vs []interface{}) error
+	// SetMulti is like Set for several items at once.
+	SetMulti(items []CacheItem) error
+	// DeleteMulti is like Delete for several keys at once, returning an
+	// appengine.MultiError like GetMulti.
+	DeleteMulti(keys []string) error
+}
+
+// CacheItem is a value to cache with SetMulti.
+type CacheItem struct {
+	Key   string
+	Value interface{}
+	TTL   time.Duration
+}
+
+// ErrCacheMiss is returned by a Cache that doesn't have the requested key.
+var ErrCacheMiss = memcache.ErrCacheMiss
+
+// ErrNotStored is returned by Add when the key is already cached.
+var ErrNotStored = memcache.ErrNotStored
+
+// errCacheUnavailable is returned by the deletes while memcache is skipped,
+// the values are still there.
+var errCacheUnavailable = errors.New("memcache is unavailable")
+
+// multiError returns the errors as an appengine.MultiError, or nil if
+// they're all nil.
+func multiError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return appengine.MultiError(errs)
+		}
+	}
+	return nil
+}
+
+// misses returns the error of a GetMulti or DeleteMulti of n keys that are
+// all missing.
+func misses(n int) error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = ErrCacheMiss
+	}
+	return multiError(errs)
+}
+
+// newCache returns the Cache used by a request, memcache unless
+// CACHE_BACKEND=memory for local runs without it.
+var newCache = func(c appengine.Context) Cache { return memcacheCache{c} }
+
+// memcacheCache is a Cache backed by memcache. It's skipped while memcache is
+// failing, see breaker, and once the request is out of time.
+type memcacheCache struct {
+	c appengine.Context
+}
+
+// context returns the context of a memcache call, or false if the call should
+// be skipped.
+func (m memcacheCache) context() (appengine.Context, bool) {
+	if !memcacheAvailable() {
+		return nil, false
+	}
+	return memcacheContext(m.c)
+}
+
+func (m memcacheCache) Get(key string, v interface{}) error {
+	mc, ok := m.context()
+	if !ok {
+		return ErrCacheMiss
+	}
+	_, err := memcache.JSON.Get(mc, key, v)
+	memcacheResult(m.c, err)
+	return err
+}
+
+func (m memcacheCache) Set(key string, v interface{}, ttl time.Duration) error {
+	mc, ok := m.context()
+	if !ok {
+		return nil
+	}
+	err := memcache.JSON.Set(mc, &memcache.Item{
+		Key:        key,
+		Object:     v,
+		Expiration: ttl,
+	})
+	memcacheResult(m.c, err)
+	return err
+}
+
+func (m memcacheCache) Add(key string, v interface{}, ttl time.Duration) error {
+	mc, ok := m.context()
+	if !ok {
+		return nil
+	}
+	err := memcache.JSON.Add(mc, &memcache.Item{
+		Key:        key,
+		Object:     v,
+		Expiration: ttl,
+	})
+	if err == ErrNotStored {
+		// memcache answered, the key is just taken
+		memcacheResult(m.c, nil)
+	} else {
+		memcacheResult(m.c, err)
+	}
+	return err
+}
+
+func (m memcacheCache) Delete(key string) error {
+	mc, ok := m.context()
+	if !ok {
+		return errCacheUnavailable
+	}
+	err := memcache.Delete(mc, key)
+	memcacheResult(m.c, err)
+	return err
+}
+
+func (m memcacheCache) GetMulti(keys []string, vs []interface{}) error {
+	mc, ok := m.context()
+	if !ok {
+		return misses(len(keys))
+	}
+	items, err := memcache.GetMulti(mc, keys)
+	memcacheResult(m.c, err)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			errs[i] = ErrCacheMiss
+			continue
+		}
+		errs[i] = json.Unmarshal(item.Value, vs[i])
+	}
+	return multiError(errs)
+}
+
+func (m memcacheCache) SetMulti(items []CacheItem) error {
+	mc, ok := m.context()
+	if !ok {
+		return nil
+	}
+	mitems := make([]*memcache.Item, len(items))
+	for i, item := range items {
+		mitems[i] = &memcache.Item{
+			Key:        item.Key,
+			Object:     item.Value,
+			Expiration: item.TTL,
+		}
+	}
+	err := memcache.JSON.SetMulti(mc, mitems)
+	memcacheResult(m.c, firstFailure(err))
+	return err
+}
+
+func (m memcacheCache) DeleteMulti(keys []string) error {
+	mc, ok := m.context()
+	if !ok {
+		return errCacheUnavailable
+	}
+	err := memcache.DeleteMulti(mc, keys)
+	memcacheResult(m.c, firstFailure(err))
+	return err
+}
+
+// firstFailure returns the first error of a memcache call on several keys
+// that is not a cache miss, or nil if there's none.
+func firstFailure(err error) error {
+	errs, ok := err.(appengine.MultiError)
+	if !ok {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil && err != ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
+
+// sharedMemoryCache is the Cache used with CACHE_BACKEND=memory.
+var sharedMemoryCache = NewMemoryCache()
+
+// MemoryCache is a Cache keeping the values in memory, for tests and local
+// runs.
+type MemoryCache struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time // zero if it never expires
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{items: make(map[string]memoryItem)}
+}
+
+func (m *MemoryCache) Get(key string, v interface{}) error {
+	m.mu.Lock()
+	item, ok := m.items[key]
+	if ok && !item.expires.IsZero() && now().After(item.expires) {
+		delete(m.items, key)
+		ok = false
+	}
+	m.mu.Unlock()
+	if !ok {
+		return ErrCacheMiss
+	}
+	return json.Unmarshal(item.value, v)
+}
+
+func (m *MemoryCache) Set(key string, v interface{}, ttl time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	item := memoryItem{value: b}
+	if ttl > 0 {
+		item.expires = now().Add(ttl)
+	}
+	m.mu.Lock()
+	m.items[key] = item
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryCache) Add(key string, v interface{}, ttl time.Duration) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	item := memoryItem{value: b}
+	if ttl > 0 {
+		item.expires = now().Add(ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.items[key]; ok && (old.expires.IsZero() || !now().After(old.expires)) {
+		return ErrNotStored
+	}
+	m.items[key] = item
+	return nil
+}
+
+func (m *MemoryCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[key]; !ok {
+		return ErrCacheMiss
+	}
+	delete(m.items, key)
+	return nil
+}
+
+func (m *MemoryCache) GetMulti(keys []string, vs []interface{}) error {
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = m.Get(key, vs[i])
+	}
+	return multiError(errs)
+}
+
+func (m *MemoryCache) SetMulti(items []CacheItem) error {
+	for _, item := range items {
+		if err := m.Set(item.Key, item.Value, item.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCache) DeleteMulti(keys []string) error {
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		errs[i] = m.Delete(key)
+	}
+	return multiError(errs)
+}
+
+// requestCache returns the Cache used by a request with the given nocache
+// parameter: "1" skips reading the cache, so the groups are always fetched
+// but still cached, and "full" skips writing it too.
+func requestCache(c appengine.Context, nocache string) (Cache, error) {
+	switch nocache {
+	case "":
+		return newCache(c), nil
+	case "1":
+		return writeOnlyCache{newCache(c)}, nil
+	case "full":
+		return noCache{}, nil
+	}
+	return nil, fmt.Errorf("invalid nocache %q, want 1 or full", nocache)
+}
+
+// writeOnlyCache is a Cache that never finds anything, but still stores the
+// values in the wrapped Cache.
+type writeOnlyCache struct {
+	Cache
+}
+
+func (writeOnlyCache) Get(key string, v interface{}) error { return ErrCacheMiss }
+
+func (writeOnlyCache) GetMulti(keys []string, vs []interface{}) error { return misses(len(keys)) }
+
+// noCache is a Cache that doesn't store anything.
+type noCache struct{}
+
+func (noCache) Get(key string, v interface{}) error                    { return ErrCacheMiss }
+func (noCache) Set(key string, v interface{}, ttl time.Duration) error { return nil }
+func (noCache) Add(key string, v interface{}, ttl time.Duration) error { return nil }
+func (noCache) Delete(key string) error                                { return ErrCacheMiss }
+func (noCache) GetMulti(keys []string, vs []interface{}) error         { return misses(len(keys)) }
+func (noCache) SetMulti(items []CacheItem) error                       { return nil }
+func (noCache) DeleteMulti(keys []string) error                        { return misses(len(keys)) }
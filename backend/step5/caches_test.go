@@ -0,0 +1,195 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"appengine"
+)
+
+// testCaches returns the Cache implementations to test, by name.
+func testCaches(t *testing.T) map[string]Cache {
+	return map[string]Cache{
+		"memcache": memcacheCache{newTestContext(t)},
+		"memory":   NewMemoryCache(),
+	}
+}
+
+func TestCaches(t *testing.T) {
+	_, _, restore := setup()
+	defer restore()
+	for name, cache := range testCaches(t) {
+		// the keys are prefixed since memcache is shared by the tests
+		key := func(k string) string { return "caches-test:" + name + ":" + k }
+		var v string
+		if err := cache.Get(key("a"), &v); err != ErrCacheMiss {
+			t.Errorf("%s: get before set: got %q, %v; want a miss", name, v, err)
+		}
+		if err := cache.Set(key("a"), "A", time.Hour); err != nil {
+			t.Errorf("%s: set: %v", name, err)
+		}
+		if err := cache.Get(key("a"), &v); err != nil || v != "A" {
+			t.Errorf("%s: get: got %q, %v; want %q", name, v, err, "A")
+		}
+		if err := cache.Delete(key("a")); err != nil {
+			t.Errorf("%s: delete: %v", name, err)
+		}
+		if err := cache.Delete(key("a")); err != ErrCacheMiss {
+			t.Errorf("%s: delete again: got %v, want a miss", name, err)
+		}
+		if err := cache.Get(key("a"), &v); err != ErrCacheMiss {
+			t.Errorf("%s: get after delete: got %q, %v; want a miss", name, v, err)
+		}
+
+		// and several keys at once
+		err := cache.SetMulti([]CacheItem{
+			{key("b"), "B", time.Hour},
+			{key("c"), cachedGroup{Group: &Group{ID: "golangsf"}}, time.Hour},
+		})
+		if err != nil {
+			t.Errorf("%s: set multi: %v", name, err)
+		}
+		var b string
+		var cg cachedGroup
+		err = cache.GetMulti([]string{key("b"), key("missing"), key("c")}, []interface{}{&b, &v, &cg})
+		errs, ok := err.(appengine.MultiError)
+		if !ok || !reflect.DeepEqual([]error(errs), []error{nil, ErrCacheMiss, nil}) {
+			t.Errorf("%s: get multi: got error %v, want a miss of the second key", name, err)
+		}
+		if b != "B" || cg.Group == nil || cg.Group.ID != "golangsf" {
+			t.Errorf("%s: get multi: got %q and %+v", name, b, cg)
+		}
+		if err := cache.GetMulti([]string{key("b")}, []interface{}{&b}); err != nil {
+			t.Errorf("%s: get multi of a cached key: %v", name, err)
+		}
+		err = cache.DeleteMulti([]string{key("b"), key("missing"), key("c")})
+		errs, ok = err.(appengine.MultiError)
+		if !ok || !reflect.DeepEqual([]error(errs), []error{nil, ErrCacheMiss, nil}) {
+			t.Errorf("%s: delete multi: got error %v, want a miss of the second key", name, err)
+		}
+		if err := cache.Get(key("b"), &b); err != ErrCacheMiss {
+			t.Errorf("%s: get after delete multi: got %v, want a miss", name, err)
+		}
+
+		// adding keeps what's there
+		if err := cache.Add(key("d"), "D", time.Hour); err != nil {
+			t.Errorf("%s: add: %v", name, err)
+		}
+		if err := cache.Add(key("d"), "E", time.Hour); err != ErrNotStored {
+			t.Errorf("%s: add again: got %v, want %v", name, err, ErrNotStored)
+		}
+		if err := cache.Get(key("d"), &v); err != nil || v != "D" {
+			t.Errorf("%s: get after add: got %q, %v; want %q", name, v, err, "D")
+		}
+		cache.Delete(key("d"))
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	start := time.Now()
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+
+	cache := NewMemoryCache()
+	cache.Set("hour", "H", time.Hour)
+	cache.Set("forever", "F", 0)
+	clock = start.Add(time.Hour + time.Second)
+	var v string
+	if err := cache.Get("hour", &v); err != ErrCacheMiss {
+		t.Errorf("get after the TTL: got %q, %v; want a miss", v, err)
+	}
+	if err := cache.Get("forever", &v); err != nil || v != "F" {
+		t.Errorf("get with no TTL: got %q, %v; want %q", v, err, "F")
+	}
+}
+
+func TestMemcacheUnavailable(t *testing.T) {
+	_, _, restore := setup()
+	defer restore()
+	cache := memcacheCache{newTestContext(t)}
+	cache.Set("unavailable-test", "U", time.Hour)
+	for i := 0; i < breakerThreshold; i++ {
+		memcacheResult(newTestContext(t), errors.New("memcache down"))
+	}
+
+	// the deletes fail since the values are still there
+	if err := cache.Delete("unavailable-test"); err != errCacheUnavailable {
+		t.Errorf("delete: got %v, want %v", err, errCacheUnavailable)
+	}
+	if removed, err := deleteKeys(cache, []string{"unavailable-test"}); removed != 0 || err != errCacheUnavailable {
+		t.Errorf("deleteKeys: got %d, %v; want 0, %v", removed, err, errCacheUnavailable)
+	}
+	var v string
+	err := cache.GetMulti([]string{"unavailable-test"}, []interface{}{&v})
+	if errs, ok := err.(appengine.MultiError); !ok || errs[0] != ErrCacheMiss {
+		t.Errorf("get multi: got %v, want a miss", err)
+	}
+	if err := cache.SetMulti([]CacheItem{{"unavailable-test", "V", time.Hour}}); err != nil {
+		t.Errorf("set multi: %v", err)
+	}
+}
+
+func TestDeleteKeys(t *testing.T) {
+	cache := NewMemoryCache()
+	cache.Set("a", "A", time.Hour)
+	cache.Set("b", "B", time.Hour)
+	if removed, err := deleteKeys(cache, []string{"a", "missing", "b"}); removed != 2 || err != nil {
+		t.Errorf("deleteKeys = %d, %v; want 2, nil", removed, err)
+	}
+	if removed, err := deleteKeys(cache, []string{"a"}); removed != 0 || err != nil {
+		t.Errorf("deleteKeys again = %d, %v; want 0, nil", removed, err)
+	}
+}
+
+func TestNoCache(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	members := func(n int) {
+		f.mu.Lock()
+		f.groups["golangsf"].Members = n
+		f.mu.Unlock()
+	}
+	check := func(query string, fetches int, hits string, want int) {
+		flushResponses()
+		w := get(t, getGroups, "/api/groups"+query)
+		var res groupsResponse
+		decode(t, w, &res)
+		if n := f.fetches("golangsf"); n != fetches {
+			t.Errorf("%q: got %d fetches, want %d", query, n, fetches)
+		}
+		if h := w.Header().Get("X-Cache-Hits"); h != hits {
+			t.Errorf("%q: got X-Cache-Hits %s, want %s", query, h, hits)
+		}
+		for _, g := range res.Groups {
+			if g.ID == "golangsf" && g.Members != want {
+				t.Errorf("%q: got %d members, want %d", query, g.Members, want)
+			}
+		}
+	}
+
+	check("", 1, "0", 100)
+	check("", 1, "3", 100)
+
+	// nocache=1 fetches again and caches what it got
+	members(101)
+	check("?nocache=1", 2, "0", 101)
+	check("", 2, "3", 101)
+
+	// nocache=full fetches again and leaves the cache alone
+	members(102)
+	check("?nocache=full", 3, "0", 102)
+	check("", 3, "3", 101)
+
+	w := get(t, getGroups, "/api/groups?nocache=yes")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("nocache=yes: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
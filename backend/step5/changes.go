@@ -0,0 +1,115 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+
+	"appengine"
+)
+
+func init() {
+	handle("/api/groups/changes", adminOnly(getChanges))
+}
+
+// changedFields are the Group fields compared by getChanges.
+var changedFields = []string{
+	"Name", "URL", "PhotoURL", "Organizer", "Members", "City", "Country",
+	"Lat", "Lon", "NextEventName", "NextEventTime",
+}
+
+// fieldChange is the value of a Group field before and after a fetch.
+type fieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// groupChange lists the fields of a group that changed, by name.
+type groupChange struct {
+	ID      string                 `json:"id"`
+	Changes map[string]fieldChange `json:"changes"`
+}
+
+// diffGroups returns the fields in changedFields that differ between the two
+// copies of a group.
+func diffGroups(before, after *Group) map[string]fieldChange {
+	changes := make(map[string]fieldChange)
+	b, a := reflect.ValueOf(*before), reflect.ValueOf(*after)
+	for _, name := range changedFields {
+		bv, av := b.FieldByName(name).Interface(), a.FieldByName(name).Interface()
+		if !reflect.DeepEqual(bv, av) {
+			changes[name] = fieldChange{bv, av}
+		}
+	}
+	return changes
+}
+
+// getChanges fetches the configured groups and replies with the ones that
+// changed since they were cached, and how. Groups that weren't cached, or
+// couldn't be fetched, are not compared. The fetched copies replace the
+// cached ones, so every change is reported once. Only the administrators can
+// get the changes, since they cost a fetch of every group.
+func getChanges(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	if !hasAPIKey() {
+		writeError(w, http.StatusInternalServerError, errNoAPIKey.Error())
+		c.Errorf("get changes: %v", errNoAPIKey)
+		return
+	}
+
+	// the cached copies are read before the fetches overwrite them
+	cache := newCache(c)
+	cgs := make([]cachedGroup, len(ids))
+	vs := make([]interface{}, len(ids))
+	for i := range cgs {
+		vs[i] = &cgs[i]
+	}
+	err := cache.GetMulti(ids, vs)
+	errs, _ := err.(appengine.MultiError)
+	if err != nil && errs == nil {
+		c.Errorf("cache get: %v", err)
+	}
+	cached := make(map[string]*Group)
+	for i, id := range ids {
+		if (errs == nil || errs[i] == nil) && cgs[i].Group != nil {
+			cached[id] = cgs[i].Group
+		}
+	}
+
+	res := struct {
+		APIVersion string        `json:"apiVersion"`
+		Changes    []groupChange `json:"changes"`
+		Errors     []fetchError  `json:"errors"`
+	}{APIVersion: apiVersion, Changes: []groupChange{}}
+
+	done := r.Context().Done()
+	ok := collect(c, ids, fetchAll(c, writeOnlyCache{cache}, ids, false, done), done, func(p partial) {
+		if p.err != nil {
+			res.Errors = append(res.Errors, newFetchError(p.id, p.err))
+			return
+		}
+		// a stale copy is not news
+		before := cached[p.id]
+		if before == nil || p.group.Stale {
+			return
+		}
+		if changes := diffGroups(before, p.group); len(changes) > 0 {
+			res.Changes = append(res.Changes, groupChange{p.id, changes})
+		}
+	})
+	if !ok {
+		return
+	}
+	sort.Slice(res.Changes, func(i, j int) bool { return res.Changes[i].ID < res.Changes[j].ID })
+	res.Errors = sortErrors(res.Errors)
+	if res.Errors == nil {
+		res.Errors = []fetchError{}
+	}
+
+	writeJSON(c, w, r, res)
+}
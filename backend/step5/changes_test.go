@@ -0,0 +1,67 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetChanges(t *testing.T) {
+	f, _, restore := setup(append(testGroups(), &Group{ID: "golang-nyc", Name: "Go NYC", Members: 70})...)
+	defer restore()
+
+	// everything but golang-nyc is cached
+	f.fail("golang-nyc", &kindError{ErrNetwork, errors.New("boom")})
+	get(t, getGroups, "/api/groups")
+	f.mu.Lock()
+	f.groups["golangsf"].Members = 120
+	delete(f.errs, "golang-nyc")
+	f.mu.Unlock()
+	f.fail("golang-paris", &kindError{ErrNetwork, errors.New("boom")})
+
+	changes := func(admin bool) (*httptest.ResponseRecorder, []groupChange, []fetchError) {
+		r := newRequest(t, "GET", "/api/groups/changes", nil)
+		if admin {
+			asAdmin(r)
+		}
+		w := httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(w, r)
+		var res struct {
+			Changes []groupChange `json:"changes"`
+			Errors  []fetchError  `json:"errors"`
+		}
+		if w.Code == http.StatusOK {
+			decode(t, w, &res)
+		}
+		return w, res.Changes, res.Errors
+	}
+
+	if w, _, _ := changes(false); w.Code != http.StatusForbidden {
+		t.Errorf("not as an administrator: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// only the member count of golangsf changed, golang-nyc is new and
+	// golang-paris couldn't be fetched
+	w, got, errs := changes(true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	want := []groupChange{{"golangsf", map[string]fieldChange{"Members": {100.0, 120.0}}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %+v, want %+v", got, want)
+	}
+	if len(errs) != 1 || errs[0].ID != "golang-paris" {
+		t.Errorf("got errors %+v, want one for golang-paris", errs)
+	}
+
+	// and it's reported only once
+	if _, got, _ := changes(true); got == nil || len(got) != 0 {
+		t.Errorf("the second time: got changes %+v, want none", got)
+	}
+}
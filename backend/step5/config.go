@@ -0,0 +1,49 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import "net/http"
+
+func init() {
+	handle("/api/config/ids", cors(getConfigIDs))
+}
+
+// getConfigIDs replies with the configured group ids, as read from the
+// environment, and the cache and timeout settings, without fetching anything.
+func getConfigIDs(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "the configuration is read only")
+		return
+	}
+
+	// the invalid ids are reported apart, since fetching them always fails
+	valid, invalid := []string{}, []string{}
+	for _, id := range ids {
+		if validID(id) {
+			valid = append(valid, id)
+		} else {
+			invalid = append(invalid, id)
+		}
+	}
+
+	writeJSON(c, w, r, struct {
+		APIVersion     string   `json:"apiVersion"`
+		IDs            []string `json:"ids"`
+		InvalidIDs     []string `json:"invalidIDs,omitempty"`
+		CacheTTL       string   `json:"cacheTTL"`
+		RequestTimeout string   `json:"requestTimeout"`
+		FetchTimeout   string   `json:"fetchTimeout"`
+	}{
+		APIVersion:     apiVersion,
+		IDs:            valid,
+		InvalidIDs:     invalid,
+		CacheTTL:       cacheTTL.String(),
+		RequestTimeout: requestTimeout.String(),
+		FetchTimeout:   fetchTimeout.String(),
+	})
+}
@@ -0,0 +1,51 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetConfigIDs(t *testing.T) {
+	f, _, restore := setup()
+	defer restore()
+	defer setEnv("GROUP_IDS", str(" golangsf, golang-paris,golangsf ,bad/id"))()
+	ids = loadIDs()
+	cacheTTL = 2 * time.Hour
+
+	w := get(t, getConfigIDs, "/api/config/ids")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	var res struct {
+		IDs            []string
+		InvalidIDs     []string
+		CacheTTL       string
+		RequestTimeout string
+		FetchTimeout   string
+	}
+	decode(t, w, &res)
+	if want := []string{"golangsf", "golang-paris"}; !reflect.DeepEqual(res.IDs, want) {
+		t.Errorf("got ids %q, want %q", res.IDs, want)
+	}
+	if want := []string{"bad/id"}; !reflect.DeepEqual(res.InvalidIDs, want) {
+		t.Errorf("got invalid ids %q, want %q", res.InvalidIDs, want)
+	}
+	if res.CacheTTL != "2h0m0s" || res.RequestTimeout != requestTimeout.String() || res.FetchTimeout != fetchTimeout.String() {
+		t.Errorf("got cache TTL %s, request timeout %s and fetch timeout %s; want 2h0m0s, %v and %v",
+			res.CacheTTL, res.RequestTimeout, res.FetchTimeout, requestTimeout, fetchTimeout)
+	}
+	if n := len(f.calls); n != 0 {
+		t.Errorf("got %d groups fetched, want none", n)
+	}
+
+	w = serve(getConfigIDs, newRequest(t, "POST", "/api/config/ids", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
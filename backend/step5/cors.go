@@ -0,0 +1,45 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"os"
+)
+
+// corsOrigin is the origin allowed to call the API from a browser, it can be
+// overridden with CORS_ORIGIN.
+var corsOrigin = "*"
+
+func init() {
+	if origin := os.Getenv("CORS_ORIGIN"); origin != "" {
+		corsOrigin = origin
+	}
+}
+
+// exposedHeaders are the response headers the browsers let the clients read,
+// besides the standard ones.
+var exposedHeaders = "X-Request-ID, X-API-Version, X-Cache-Hits, X-Cache-Misses, X-Fetch-Errors, X-Missing-Coordinates, X-Response-Time"
+
+// cors adds the CORS headers to the responses of h, and replies to preflight
+// requests without calling it.
+func cors(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", corsOrigin)
+		if corsOrigin != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+		h(w, r)
+	}
+}
@@ -0,0 +1,59 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	f, _, restore := setup(testGroups()...)
+	defer restore()
+	h := cors(getGroups)
+
+	// the preflight is answered without fetching anything
+	w := serve(h, newRequest(t, "OPTIONS", "/api/groups", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("preflight: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for h, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "*",
+		"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
+		"Access-Control-Allow-Headers": "Content-Type, If-None-Match, X-Request-ID",
+	} {
+		if got := w.Header().Get(h); got != want {
+			t.Errorf("preflight: got %s %q, want %q", h, got, want)
+		}
+	}
+	if n := f.fetches("golangsf"); n != 0 {
+		t.Errorf("preflight: fetched golangsf %d times, want none", n)
+	}
+
+	w = serve(h, newRequest(t, "GET", "/api/groups", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("GET: got Access-Control-Allow-Origin %q, want *", got)
+	}
+
+	// and the clients can read the headers set by the handlers
+	exposed := make(map[string]bool)
+	for _, name := range strings.Split(w.Header().Get("Access-Control-Expose-Headers"), ",") {
+		exposed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	for name := range w.Header() {
+		if strings.HasPrefix(name, "X-") && !exposed[name] {
+			t.Errorf("GET: %s is not exposed", name)
+		}
+	}
+	for _, name := range []string{"X-Request-Id", "X-Cache-Hits", "X-Cache-Misses", "X-Fetch-Errors", "X-Response-Time"} {
+		if !exposed[name] {
+			t.Errorf("GET: %s is not exposed", name)
+		}
+	}
+}
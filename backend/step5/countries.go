@@ -0,0 +1,47 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+// countryNames maps ISO 3166-1 alpha-2 codes to the country names, only for
+// the countries most likely to have a Go meetup.
+var countryNames = map[string]string{
+	"AR": "Argentina",
+	"AT": "Austria",
+	"AU": "Australia",
+	"BE": "Belgium",
+	"BR": "Brazil",
+	"CA": "Canada",
+	"CH": "Switzerland",
+	"CL": "Chile",
+	"CN": "China",
+	"CO": "Colombia",
+	"CZ": "Czech Republic",
+	"DE": "Germany",
+	"DK": "Denmark",
+	"ES": "Spain",
+	"FI": "Finland",
+	"FR": "France",
+	"GB": "United Kingdom",
+	"IE": "Ireland",
+	"IL": "Israel",
+	"IN": "India",
+	"IT": "Italy",
+	"JP": "Japan",
+	"KR": "South Korea",
+	"MX": "Mexico",
+	"NL": "Netherlands",
+	"NO": "Norway",
+	"NZ": "New Zealand",
+	"PL": "Poland",
+	"PT": "Portugal",
+	"RU": "Russia",
+	"SE": "Sweden",
+	"SG": "Singapore",
+	"TR": "Turkey",
+	"TW": "Taiwan",
+	"UA": "Ukraine",
+	"US": "United States",
+	"ZA": "South Africa",
+}
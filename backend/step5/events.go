@@ -0,0 +1,88 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"appengine"
+)
+
+// Event is an upcoming event of a meetup group.
+type Event struct {
+	Name      string
+	Time      time.Time
+	RSVPCount int
+}
+
+// eventsTTL is how long the events of a group are cached, shorter than
+// cacheTTL since they change more often.
+var eventsTTL = 15 * time.Minute
+
+// loadEvents returns the upcoming events of the group with the given id from
+// the cache, fetching them from meetup when they're not cached yet.
+func loadEvents(c appengine.Context, id string) ([]Event, error) {
+	key := "events:" + id
+	cache := newCache(c)
+
+	var events []Event
+	err := cache.Get(key, &events)
+	if err == nil {
+		return events, nil
+	}
+	if err != ErrCacheMiss {
+		c.Errorf("cache get %q: %v", key, err)
+	}
+
+	events, err = fetchEvents(c, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cache.Set(key, events, eventsTTL); err != nil {
+		c.Errorf("cache set %q: %v", key, err)
+	}
+	return events, nil
+}
+
+// fetchEvents fetches the upcoming events of a meetup group given its id.
+func fetchEvents(c appengine.Context, id string) ([]Event, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("invalid id %q", id)
+	}
+
+	res, err := meetupGet(c, id+"/events", url.Values{"status": {"upcoming"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var evs []struct {
+		Name      string `json:"name"`
+		Time      int64  `json:"time"` // milliseconds since the epoch
+		RSVPCount int    `json:"yes_rsvp_count"`
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &kindError{ErrNetwork, fmt.Errorf("read: %v", err)}
+	}
+	if err := json.Unmarshal(body, &evs); err != nil {
+		return nil, &kindError{ErrMeetupAPI, fmt.Errorf("decode %v events: %v (body: %q)", id, err, snippet(body))}
+	}
+
+	events := make([]Event, 0, len(evs))
+	for _, e := range evs {
+		events = append(events, Event{
+			Name:      e.Name,
+			Time:      time.Unix(0, e.Time*int64(time.Millisecond)).UTC(),
+			RSVPCount: e.RSVPCount,
+		})
+	}
+	return events, nil
+}
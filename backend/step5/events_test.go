@@ -0,0 +1,77 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetGroupsEvents(t *testing.T) {
+	_, _, restore := setup(testGroups()[:2]...)
+	defer restore()
+	requests := 0
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/golangsf/events" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `[{"name": "Go 1.9", "time": 1500000000000, "yes_rsvp_count": 42}]`)
+	})()
+
+	w := get(t, getGroups, "/api/groups?events=1")
+	var res struct {
+		Groups []map[string]json.RawMessage
+		Errors []fetchError
+	}
+	decode(t, w, &res)
+	if len(res.Groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(res.Groups))
+	}
+	for _, g := range res.Groups {
+		var id string
+		var events []Event
+		json.Unmarshal(g["ID"], &id)
+		raw, ok := g["Events"]
+		if !ok {
+			t.Errorf("%s: no events", id)
+			continue
+		}
+		if err := json.Unmarshal(raw, &events); err != nil {
+			t.Errorf("%s: decode events: %v", id, err)
+		}
+		switch id {
+		case "golangsf":
+			want := []Event{{"Go 1.9", time.Unix(1500000000, 0).UTC(), 42}}
+			if len(events) != 1 || events[0] != want[0] {
+				t.Errorf("%s: got events %+v, want %+v", id, events, want)
+			}
+		case "golang-paris":
+			// the events failed to load, so they're empty but present
+			if string(raw) != "[]" {
+				t.Errorf("%s: got events %s, want []", id, raw)
+			}
+		}
+	}
+	if len(res.Errors) != 1 || res.Errors[0].ID != "golang-paris" || !strings.HasPrefix(res.Errors[0].Message, "events: ") {
+		t.Errorf("got errors %+v, want one for the events of golang-paris", res.Errors)
+	}
+
+	// the events are cached, and left out without events=1
+	n := requests
+	flushResponses()
+	w = get(t, getGroups, "/api/groups?events=1&sort=name")
+	if requests != n+retries+1 {
+		t.Errorf("got %d more requests, want only the %d for the failed events", requests-n, retries+1)
+	}
+	if w = get(t, getGroups, "/api/groups"); strings.Contains(w.Body.String(), `"Events"`) {
+		t.Errorf("got events without events=1: %s", w.Body)
+	}
+}
@@ -0,0 +1,78 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"container/list"
+	"os"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	if v, ok := os.LookupEnv("FALLBACK_SIZE"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			fallback = newGroupLRU(n)
+		}
+	}
+}
+
+// fallback keeps the groups fetched most recently by this instance, to be
+// served when neither the cache nor meetup have them. Unlike the last good
+// copies it survives memcache evictions and flushes. Its size can be set with
+// FALLBACK_SIZE, 0 disables it.
+var fallback = newGroupLRU(500)
+
+// groupLRU is a concurrency safe set of groups by id, which drops the least
+// recently used one when it's full.
+type groupLRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of *Group, the most recently used first
+	items map[string]*list.Element
+}
+
+func newGroupLRU(size int) *groupLRU {
+	return &groupLRU{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// add stores a copy of the group, replacing any other with the same id.
+func (l *groupLRU) add(group *Group) {
+	if l.size <= 0 {
+		return
+	}
+	g := *group
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.items[g.ID]; ok {
+		e.Value = &g
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[g.ID] = l.order.PushFront(&g)
+	for l.order.Len() > l.size {
+		e := l.order.Back()
+		l.order.Remove(e)
+		delete(l.items, e.Value.(*Group).ID)
+	}
+}
+
+// get returns a copy of the group with the given id, marked as stale.
+func (l *groupLRU) get(id string) (*Group, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.items[id]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	g := *e.Value.(*Group)
+	g.Stale = true
+	return &g, true
+}
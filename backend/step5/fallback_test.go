@@ -0,0 +1,84 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGroupLRU(t *testing.T) {
+	l := newGroupLRU(2)
+	l.add(&Group{ID: "golangsf", Members: 100})
+	l.add(&Group{ID: "golang-paris", Members: 50})
+
+	// using golangsf makes golang-paris the least recently used
+	if g, ok := l.get("golangsf"); !ok || g.Members != 100 || !g.Stale {
+		t.Errorf("get golangsf: got %+v, %v; want a stale copy with 100 members", g, ok)
+	}
+	l.add(&Group{ID: "golang-users-berlin", Members: 80})
+	if _, ok := l.get("golang-paris"); ok {
+		t.Error("golang-paris was not evicted")
+	}
+	for _, id := range []string{"golangsf", "golang-users-berlin"} {
+		if _, ok := l.get(id); !ok {
+			t.Errorf("%s was evicted", id)
+		}
+	}
+
+	// adding a group again replaces it
+	l.add(&Group{ID: "golangsf", Members: 101})
+	if g, _ := l.get("golangsf"); g == nil || g.Members != 101 {
+		t.Errorf("get golangsf after adding it again: got %+v, want 101 members", g)
+	}
+	if n := l.order.Len(); n != 2 {
+		t.Errorf("got %d groups, want 2", n)
+	}
+
+	// the copies can't change the stored groups
+	g, _ := l.get("golangsf")
+	g.Members = 0
+	if g, _ := l.get("golangsf"); g.Members != 101 {
+		t.Errorf("got %d members after changing a copy, want 101", g.Members)
+	}
+
+	off := newGroupLRU(0)
+	off.add(&Group{ID: "golangsf"})
+	if _, ok := off.get("golangsf"); ok {
+		t.Error("a disabled LRU kept a group")
+	}
+}
+
+func TestLoadFallback(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	fallback = newGroupLRU(10)
+	c := newTestContext(t)
+	if _, _, err := load(c, cache, f, "golangsf"); err != nil {
+		t.Fatal(err)
+	}
+
+	// memcache loses everything and meetup fails
+	for key := range cache.items {
+		cache.Delete(key)
+	}
+	f.fail("golangsf", &kindError{ErrNetwork, errors.New("boom")})
+	g, cached, err := load(c, cache, f, "golangsf")
+	if err != nil || !cached || g.Name != "GoSF" || !g.Stale {
+		t.Errorf("load with memcache flushed and meetup down: got %+v, %v, %v; want a stale GoSF", g, cached, err)
+	}
+
+	// but a group that's gone is gone
+	f.fail("golangsf", ErrNotFound)
+	if g, _, err := load(c, cache, f, "golangsf"); err != ErrNotFound {
+		t.Errorf("load of a deleted group: got %+v, %v; want %v", g, err, ErrNotFound)
+	}
+
+	// and the groups never fetched are not there
+	f.fail("golang-paris", &kindError{ErrNetwork, errors.New("boom")})
+	if _, _, err := load(c, cache, f, "golang-paris"); err == nil {
+		t.Error("load of a group never fetched: got no error")
+	}
+}
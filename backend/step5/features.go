@@ -0,0 +1,89 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// features are the boolean options of a request. Each one is enabled with its
+// own parameter set to 1, as in ?debug=1, or by listing it in the features
+// parameter, as in ?features=events,debug.
+type features struct {
+	Debug      bool
+	Events     bool
+	Growth     bool
+	Pretty     bool
+	Sequential bool
+	Stream     bool
+}
+
+// flag returns the option with the given name, or nil if there's none.
+func (f *features) flag(name string) *bool {
+	switch name {
+	case "debug":
+		return &f.Debug
+	case "events":
+		return &f.Events
+	case "growth":
+		return &f.Growth
+	case "pretty":
+		return &f.Pretty
+	case "sequential":
+		return &f.Sequential
+	case "stream":
+		return &f.Stream
+	}
+	return nil
+}
+
+// featureNames are the names of the options in features.
+var featureNames = []string{"debug", "events", "growth", "pretty", "sequential", "stream"}
+
+// groupsFeatures are the options used by getGroups, and groupFeatures the ones
+// used by getGroup.
+var (
+	groupsFeatures = []string{"debug", "events", "pretty", "sequential", "stream"}
+	groupFeatures  = []string{"growth", "pretty"}
+)
+
+// parseFeatures returns the options enabled in the request among the given
+// names, or an error if the features parameter lists any unknown one, or one
+// that is not among them, so they're not silently ignored.
+func parseFeatures(r *http.Request, names []string) (features, error) {
+	var f features
+	allowed := make(map[string]bool)
+	for _, name := range names {
+		allowed[name] = true
+		if r.FormValue(name) == "1" {
+			*f.flag(name) = true
+		}
+	}
+	var unknown, unsupported []string
+	for _, name := range strings.Split(r.FormValue("features"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		p := f.flag(name)
+		switch {
+		case p == nil:
+			unknown = append(unknown, name)
+		case !allowed[name]:
+			unsupported = append(unsupported, name)
+		default:
+			*p = true
+		}
+	}
+	if len(unknown) > 0 {
+		return f, fmt.Errorf("unknown features: %s", strings.Join(unknown, ", "))
+	}
+	if len(unsupported) > 0 {
+		return f, fmt.Errorf("unsupported features: %s, want %s", strings.Join(unsupported, ", "), strings.Join(names, ", "))
+	}
+	return f, nil
+}
@@ -0,0 +1,86 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseFeatures(t *testing.T) {
+	tests := []struct {
+		query string
+		want  features
+		ok    bool
+	}{
+		{"", features{}, true},
+		{"debug=1", features{Debug: true}, true},
+		{"debug=0", features{}, true},
+		{"features=events,debug", features{Debug: true, Events: true}, true},
+		{"features= Pretty , ,stream", features{Pretty: true, Stream: true}, true},
+		{"features=growth&sequential=1", features{Growth: true, Sequential: true}, true},
+		{"features=debug&debug=1", features{Debug: true}, true},
+		{"features=debug,nope,others", features{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseFeatures(newRequest(t, "GET", "/api/groups?"+tt.query, nil), featureNames)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got error %v, want ok %v", tt.query, err, tt.ok)
+			continue
+		}
+		if want := "unknown features: nope, others"; !tt.ok && err.Error() != want {
+			t.Errorf("%q: got error %q, want %q", tt.query, err, want)
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	// the features not among the given names are rejected too, and not
+	// enabled by their own parameter
+	r := newRequest(t, "GET", "/api/groups?features=debug,growth&growth=1&stream=1", nil)
+	if _, err := parseFeatures(r, []string{"debug", "pretty"}); err == nil || err.Error() != "unsupported features: growth, want debug, pretty" {
+		t.Errorf("features=debug,growth with debug and pretty: got error %v, want growth unsupported", err)
+	}
+	r = newRequest(t, "GET", "/api/groups?debug=1&growth=1", nil)
+	if got, err := parseFeatures(r, []string{"debug"}); err != nil || got != (features{Debug: true}) {
+		t.Errorf("debug=1&growth=1 with debug: got %+v, %v; want only debug", got, err)
+	}
+
+	// every feature has a name
+	for _, name := range featureNames {
+		var f features
+		if p := f.flag(name); p == nil {
+			t.Errorf("no feature named %s", name)
+		}
+	}
+}
+
+func TestGetGroupsFeatures(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+
+	var res groupsResponse
+	decode(t, get(t, getGroups, "/api/groups?features=debug"), &res)
+	if len(res.Debug) != 3 {
+		t.Errorf("features=debug: got debug %v, want the source of the 3 groups", res.Debug)
+	}
+
+	for _, tt := range []struct {
+		h   http.HandlerFunc
+		url string
+	}{
+		{getGroups, "/api/groups?features=debug,nope"},
+		{getGroup, "/api/group/golangsf?features=nope"},
+		// growth is only computed for a single group
+		{getGroups, "/api/groups?features=growth"},
+		{getGroups, "/api/groups?growth=1"},
+		{getGroup, "/api/group/golangsf?features=stream"},
+	} {
+		if w := get(t, tt.h, tt.url); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", tt.url, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// groupFields maps the lower case names of the Group JSON fields to the names
+// used in the JSON objects.
+var groupFields = func() map[string]string {
+	fields := make(map[string]string)
+	t := reflect.TypeOf(Group{})
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; tag != "" {
+			name = tag
+		}
+		fields[strings.ToLower(name)] = name
+	}
+	return fields
+}()
+
+// parseFields returns the JSON names of the comma separated Group fields, in
+// any case, or an error if any of them doesn't exist.
+func parseFields(list string) ([]string, error) {
+	var fields, unknown []string
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if name, ok := groupFields[strings.ToLower(f)]; ok {
+			fields = append(fields, name)
+		} else {
+			unknown = append(unknown, f)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+	}
+	return fields, nil
+}
+
+// projectGroups returns a value encoding res as JSON, but with only the given
+// fields in each one of the groups.
+func projectGroups(res groupsJSON, fields []string) interface{} {
+	groups := make([]projectedGroup, len(res.Groups))
+	for i, g := range res.Groups {
+		groups[i] = projectedGroup{g, fields}
+	}
+
+	// the outer Groups field hides the one in groupsResponse
+	return struct {
+		groupsJSON
+		Groups []projectedGroup
+	}{res, groups}
+}
+
+// projectedGroup is a Group encoded with only some of its JSON fields.
+type projectedGroup struct {
+	g      *Group
+	fields []string
+}
+
+// projectedGroup satisfies json.Marshaler.
+func (p projectedGroup) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(p.g)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	n := 0
+	for _, f := range p.fields {
+		v, ok := all[f]
+		if !ok {
+			continue
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		n++
+		fmt.Fprintf(buf, "%q:", f)
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
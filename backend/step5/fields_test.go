@@ -0,0 +1,55 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGetGroupsFields(t *testing.T) {
+	tests := []struct {
+		fields string
+		status int
+		want   []string // the fields of every group
+	}{
+		{"name,members", http.StatusOK, []string{"Members", "Name"}},
+		{" ID , countrycode", http.StatusOK, []string{"CountryCode", "ID"}},
+		{"name,nope", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		w := get(t, getGroups, "/api/groups?fields="+tt.fields)
+		restore()
+		if w.Code != tt.status {
+			t.Errorf("fields=%s: got status %d, want %d", tt.fields, w.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var res struct {
+			Groups       []map[string]json.RawMessage
+			TotalMembers int
+		}
+		decode(t, w, &res)
+		if len(res.Groups) != 3 || res.TotalMembers != 230 {
+			t.Errorf("fields=%s: got %d groups with %d members, want 3 with 230", tt.fields, len(res.Groups), res.TotalMembers)
+		}
+		for _, g := range res.Groups {
+			var got []string
+			for f := range g {
+				got = append(got, f)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields=%s: got fields %q, want %q", tt.fields, got, tt.want)
+			}
+		}
+	}
+}
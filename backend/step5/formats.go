@@ -0,0 +1,175 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// formatTypes are the media types of the response formats.
+var formatTypes = []struct {
+	format, mediaType string
+}{
+	{"json", "application/json"},
+	{"geojson", "application/geo+json"},
+	{"html", "text/html"},
+	{"csv", "text/csv"},
+}
+
+// validFormat reports whether format is one of formatTypes.
+func validFormat(format string) bool {
+	for _, t := range formatTypes {
+		if t.format == format {
+			return true
+		}
+	}
+	return false
+}
+
+// responseFormat returns the format of the response to the request: the one
+// given in the format parameter, or the one accepted with the highest quality
+// in the Accept header, the first one listed on ties. JSON by default.
+func responseFormat(r *http.Request) string {
+	if format := r.FormValue("format"); format != "" {
+		return format
+	}
+
+	best, bestQ := "json", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		for _, t := range formatTypes {
+			if t.mediaType == mediaType && q > bestQ {
+				best, bestQ = t.format, q
+			}
+		}
+	}
+	return best
+}
+
+// encodeCSV writes the groups to w as CSV, with a header row.
+func encodeCSV(w io.Writer, groups []*Group) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Name", "URL", "Members", "City", "Country"})
+	for _, g := range groups {
+		cw.Write([]string{g.Name, g.URL, strconv.Itoa(g.Members), g.City, g.Country})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// groupsHTML renders a groupsResponse as an HTML table.
+var groupsHTML = template.Must(template.New("groups").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Go meetups</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 12px; border-bottom: 1px solid #ddd; text-align: left; }
+td.members { text-align: right; }
+.errors { color: #a00; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>Name</th><th>Members</th><th>City</th><th>Country</th></tr>
+{{range .Groups}}<tr>
+  <td><a href="{{.URL}}">{{.Name}}</a></td>
+  <td class="members">{{.Members}}</td>
+  <td>{{.City}}</td>
+  <td>{{.Country}}</td>
+</tr>
+{{end}}</table>
+{{with .Errors}}<ul class="errors">
+{{range .}}<li>{{.ID}}: {{.Message}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+// encodeHTML writes the response to w as an HTML page.
+func encodeHTML(w io.Writer, res *groupsResponse) error {
+	return groupsHTML.Execute(w, res)
+}
+
+// geoJSON is a GeoJSON FeatureCollection of groups.
+type geoJSON struct {
+	Type     string       `json:"type"`
+	Features []geoFeature `json:"features"`
+}
+
+type geoFeature struct {
+	Type       string        `json:"type"`
+	Geometry   geoPoint      `json:"geometry"`
+	Properties geoProperties `json:"properties"`
+}
+
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude first
+}
+
+type geoProperties struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	URL     string `json:"url"`
+}
+
+// encodeGeoJSON writes the groups to w as a GeoJSON FeatureCollection of
+// points, and returns the ids of the groups left out because they have no
+// coordinates.
+func encodeGeoJSON(w io.Writer, groups []*Group) (missing []string, err error) {
+	fc := geoJSON{Type: "FeatureCollection", Features: []geoFeature{}}
+	for _, g := range groups {
+		if g.Lat == 0 && g.Lon == 0 {
+			missing = append(missing, g.ID)
+			continue
+		}
+		fc.Features = append(fc.Features, geoFeature{
+			Type:       "Feature",
+			Geometry:   geoPoint{"Point", [2]float64{g.Lon, g.Lat}},
+			Properties: geoProperties{g.ID, g.Name, g.Members, g.URL},
+		})
+	}
+	return missing, json.NewEncoder(w).Encode(fc)
+}
+
+// callbackRE matches the JSONP callback names we accept, anything else could
+// be used to inject code in the response.
+var callbackRE = regexp.MustCompile(`^[A-Za-z0-9_$.]+$`)
+
+// validCallback reports whether name is a safe JSONP callback name.
+func validCallback(name string) bool {
+	return callbackRE.MatchString(name)
+}
+
+// wrapJSONP returns the given JSON wrapped in a call to callback.
+func wrapJSONP(callback string, js []byte) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	buf.WriteString(callback)
+	buf.WriteByte('(')
+	buf.Write(bytes.TrimSpace(js))
+	buf.WriteString(");\n")
+	return buf
+}
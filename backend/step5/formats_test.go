@@ -0,0 +1,225 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetGroupsCSV(t *testing.T) {
+	f, _, restore := setup(
+		&Group{ID: "golangsf", Name: "Go, San Francisco", URL: "https://www.meetup.com/golangsf/", Members: 100, City: "San Francisco", Country: "us"},
+		&Group{ID: "golang-paris", Name: `Golang "Paris"`, URL: "https://www.meetup.com/golang-paris/", Members: 50, City: "Paris", Country: "fr"},
+		&Group{ID: "golang-users-berlin"},
+	)
+	defer restore()
+	f.fail("golang-users-berlin", &kindError{ErrNetwork, errors.New("boom")})
+
+	w := get(t, getGroups, "/api/groups?format=csv")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("got Content-Type %q, want text/csv", ct)
+	}
+	if n := w.Header().Get("X-Fetch-Errors"); n != "1" {
+		t.Errorf("got X-Fetch-Errors %q, want 1", n)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	want := [][]string{
+		{"Name", "URL", "Members", "City", "Country"},
+		{"Go, San Francisco", "https://www.meetup.com/golangsf/", "100", "San Francisco", "us"},
+		{`Golang "Paris"`, "https://www.meetup.com/golang-paris/", "50", "Paris", "fr"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %q, want %q", rows, want)
+	}
+}
+
+func TestValidCallback(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"cb", true},
+		{"jQuery_123$.cb", true},
+		{"alert(1)", false},
+		{"cb;alert", false},
+		{"<script>", false},
+		{"a b", false},
+	}
+	for _, tt := range tests {
+		if ok := validCallback(tt.name); ok != tt.ok {
+			t.Errorf("validCallback(%q) = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestGetGroupsJSONP(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+
+	w := get(t, getGroups, "/api/groups?callback=show")
+	if ct := w.Header().Get("Content-Type"); ct != "application/javascript" {
+		t.Errorf("got Content-Type %q, want application/javascript", ct)
+	}
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "show({") || !strings.HasSuffix(body, "});\n") {
+		t.Errorf("got %q, want the JSON wrapped in show(...);", body)
+	}
+
+	w = get(t, getGroups, "/api/groups?callback=alert(1)")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("with an invalid callback: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetGroupsHTML(t *testing.T) {
+	f, _, restore := setup(
+		&Group{ID: "golangsf", Name: "GoSF <3", URL: "https://www.meetup.com/golangsf/", Members: 100, City: "San Francisco", Country: "us"},
+		&Group{ID: "golang-paris"},
+	)
+	defer restore()
+	f.fail("golang-paris", &kindError{ErrNetwork, errors.New("boom & bust")})
+
+	w := get(t, getGroups, "/api/groups?format=html")
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("got Content-Type %q, want text/html; charset=utf-8", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<td><a href="https://www.meetup.com/golangsf/">GoSF &lt;3</a></td>`,
+		`<td class="members">100</td>`,
+		`<td>San Francisco</td>`,
+		`<li>golang-paris: boom &amp; bust</li>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the page doesn't contain %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "GoSF <3") {
+		t.Error("the group name is not escaped")
+	}
+}
+
+func TestGetGroupsGeoJSON(t *testing.T) {
+	_, _, restore := setup(
+		&Group{ID: "golangsf", Name: "GoSF", URL: "https://www.meetup.com/golangsf/", Members: 100, Lat: 37.77, Lon: -122.41},
+		&Group{ID: "golang-paris", Name: "Golang Paris", Members: 50},
+		&Group{ID: "golang-users-berlin", Name: "Go Users Berlin", URL: "https://www.meetup.com/golang-users-berlin/", Members: 80, Lat: 52.52, Lon: 13.4},
+	)
+	defer restore()
+
+	w := get(t, getGroups, "/api/groups?format=geojson")
+	if ct := w.Header().Get("Content-Type"); ct != "application/geo+json" {
+		t.Errorf("got Content-Type %q, want application/geo+json", ct)
+	}
+	if missing := w.Header().Get("X-Missing-Coordinates"); missing != "golang-paris" {
+		t.Errorf("got X-Missing-Coordinates %q, want golang-paris", missing)
+	}
+
+	// decoded without the package types, as a mapping library would, in
+	// the usual order by name
+	var got map[string]interface{}
+	decode(t, w, &got)
+	want := map[string]interface{}{
+		"type": "FeatureCollection",
+		"features": []interface{}{
+			map[string]interface{}{
+				"type":     "Feature",
+				"geometry": map[string]interface{}{"type": "Point", "coordinates": []interface{}{13.4, 52.52}},
+				"properties": map[string]interface{}{
+					"id": "golang-users-berlin", "name": "Go Users Berlin", "members": 80.0, "url": "https://www.meetup.com/golang-users-berlin/",
+				},
+			},
+			map[string]interface{}{
+				"type":     "Feature",
+				"geometry": map[string]interface{}{"type": "Point", "coordinates": []interface{}{-122.41, 37.77}},
+				"properties": map[string]interface{}{
+					"id": "golangsf", "name": "GoSF", "members": 100.0, "url": "https://www.meetup.com/golangsf/",
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s, want %v", w.Body, want)
+	}
+
+	// with no coordinates at all the collection is empty, not null
+	var empty geoJSON
+	w.Body.Reset()
+	missing, err := encodeGeoJSON(w.Body, []*Group{{ID: "golang-paris"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode(t, w, &empty)
+	if empty.Type != "FeatureCollection" || empty.Features == nil || len(empty.Features) != 0 || !reflect.DeepEqual(missing, []string{"golang-paris"}) {
+		t.Errorf("got %s missing %q, want an empty FeatureCollection missing golang-paris", w.Body, missing)
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   string
+	}{
+		{"", "", "json"},
+		{"", "*/*", "json"},
+		{"", "text/csv", "csv"},
+		{"", "text/html,application/xhtml+xml,*/*;q=0.8", "html"},
+		{"", "application/geo+json", "geojson"},
+		{"", "Text/CSV", "csv"},
+		{"", "application/xml", "json"},
+		{"", "text/csv;q=0.5, text/html;q=0.9", "html"},
+		{"", "text/html;q=0.2, text/csv", "csv"},
+		{"", "text/csv, text/html", "csv"},
+		{"", "text/csv;q=0", "json"},
+		{"", "text/csv;q=oops", "csv"},
+		{"?format=csv", "text/html", "csv"},
+		{"?format=json", "text/csv", "json"},
+	}
+	for _, tt := range tests {
+		r := newRequest(t, "GET", "/api/groups"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := responseFormat(r); got != tt.want {
+			t.Errorf("%s with Accept %q: got format %s, want %s", tt.query, tt.accept, got, tt.want)
+		}
+	}
+
+	// the handler replies accordingly
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	r := newRequest(t, "GET", "/api/groups", nil)
+	r.Header.Set("Accept", "text/html;q=0.5, text/csv")
+	w := serve(getGroups, r)
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("with Accept text/csv: got Content-Type %q, want text/csv", ct)
+	}
+	if vary := w.Header()["Vary"]; !reflect.DeepEqual(vary, []string{"Accept", "Accept-Encoding"}) {
+		t.Errorf("got Vary %q, want Accept and Accept-Encoding", vary)
+	}
+
+	// an unknown format is an error, not JSON
+	for _, format := range []string{"cvs", "JSON", "xml"} {
+		w := get(t, getGroups, "/api/groups?format="+format)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("format=%s: got status %d, want %d", format, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	// and the cached CSV response is not served to the JSON clients
+	w = get(t, getGroups, "/api/groups")
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("without Accept after a CSV response: got Content-Type %q, want application/json", ct)
+	}
+}
@@ -0,0 +1,89 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/memcache"
+	"appengine/urlfetch"
+)
+
+func init() {
+	handle("/healthz", healthz)
+}
+
+// healthTimeout bounds every call done by a health check.
+var healthTimeout = 2 * time.Second
+
+// healthz replies with 200 when memcache works, and also the meetup API when
+// ?deep=1 is given, or with 503 and the failing subsystems otherwise.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	var res struct {
+		Status string            `json:"status"`
+		Errors map[string]string `json:"errors,omitempty"`
+	}
+	fail := func(subsystem string, err error) {
+		if res.Errors == nil {
+			res.Errors = make(map[string]string)
+		}
+		res.Errors[subsystem] = err.Error()
+		c.Errorf("healthz %v: %v", subsystem, err)
+	}
+
+	// a memcache round trip
+	const key = "healthz"
+	mc := appengine.Timeout(c, healthTimeout)
+	err := memcache.Set(mc, &memcache.Item{
+		Key:        key,
+		Value:      []byte("ok"),
+		Expiration: time.Minute,
+	})
+	if err != nil {
+		fail("memcache", fmt.Errorf("set: %v", err))
+	} else if _, err := memcache.Get(mc, key); err != nil {
+		fail("memcache", fmt.Errorf("get: %v", err))
+	}
+
+	// and optionally check we can reach meetup
+	if r.FormValue("deep") == "1" {
+		client := &http.Client{Transport: &urlfetch.Transport{
+			Context:  c,
+			Deadline: healthTimeout,
+		}}
+		var resp *http.Response
+		req, err := http.NewRequest("HEAD", apiBaseURL+"/", nil)
+		if err == nil {
+			req.Header.Set("User-Agent", userAgent)
+			resp, err = client.Do(req)
+		}
+		if err != nil {
+			fail("urlfetch", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				fail("urlfetch", statusError(resp.StatusCode))
+			}
+		}
+	}
+
+	status := http.StatusOK
+	res.Status = "ok"
+	if len(res.Errors) > 0 {
+		status = http.StatusServiceUnavailable
+		res.Status = "unavailable"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := newEncoder(w, r).Encode(res); err != nil {
+		c.Errorf("encode response: %v", err)
+	}
+}
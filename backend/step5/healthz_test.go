@@ -0,0 +1,66 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	defer func(old time.Duration) { healthTimeout = old }(healthTimeout)
+	healthTimeout = 100 * time.Millisecond
+	tests := []struct {
+		name   string
+		url    string
+		meetup http.HandlerFunc
+		status int
+		errors map[string]bool
+	}{
+		{"shallow", "/healthz", nil, http.StatusOK, nil},
+		{"deep", "/healthz?deep=1", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, nil},
+		{"meetup down", "/healthz?deep=1", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, http.StatusServiceUnavailable, map[string]bool{"urlfetch": true}},
+		{"meetup slow", "/healthz?deep=1", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Second)
+		}, http.StatusServiceUnavailable, map[string]bool{"urlfetch": true}},
+		// the shallow check doesn't ask meetup
+		{"shallow with meetup down", "/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		stop := func() {}
+		if tt.meetup != nil {
+			stop = meetupServer(tt.meetup)
+		}
+		start := time.Now()
+		w := get(t, healthz, tt.url)
+		elapsed := time.Since(start)
+		stop()
+
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("%s: took %v, want it bounded by %v", tt.name, elapsed, healthTimeout)
+		}
+		var res struct {
+			Status string
+			Errors map[string]string
+		}
+		decode(t, w, &res)
+		if len(res.Errors) != len(tt.errors) {
+			t.Errorf("%s: got errors %q, want %v", tt.name, res.Errors, tt.errors)
+		}
+		for subsystem := range tt.errors {
+			if res.Errors[subsystem] == "" {
+				t.Errorf("%s: got errors %q, want one for %s", tt.name, res.Errors, subsystem)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/delay"
+)
+
+func init() {
+	recordHistory = os.Getenv("RECORD_HISTORY") == "1"
+	handle("/api/groups/", cors(getHistory))
+}
+
+// recordHistory enables keeping the member count of every fetched group in
+// the datastore, set RECORD_HISTORY=1 to enable it.
+var recordHistory bool
+
+// historyKind is the datastore kind of the history records, stored with the
+// group as their parent so they can be queried per group.
+const historyKind = "GroupHistory"
+
+// historyRecord is the member count of a group at some point in time.
+type historyRecord struct {
+	ID        string
+	Members   int
+	FetchedAt time.Time
+}
+
+// maxHistoryDays is the maximum number of days of history a request can ask
+// for.
+const maxHistoryDays = 365
+
+func groupKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, "Group", id, 0, nil)
+}
+
+// saveHistory records the member count of the group, if recording the history
+// is enabled. The record is saved in a task, so the request doesn't wait for
+// the datastore.
+func saveHistory(c appengine.Context, group *Group) {
+	if !recordHistory {
+		return
+	}
+	queueHistory(c, historyRecord{group.ID, group.Members, group.Fetched})
+}
+
+// saveHistoryLater saves a history record in a task queue task, failures are
+// only logged.
+var saveHistoryLater = delay.Func("save-history", func(c appengine.Context, rec historyRecord) {
+	key := datastore.NewIncompleteKey(c, historyKind, groupKey(c, rec.ID))
+	if _, err := datastore.Put(c, key, &rec); err != nil {
+		c.Errorf("save history %v: %v", rec.ID, err)
+	}
+})
+
+// queueHistory queues the saving of a history record, tests replace it to
+// see what's queued.
+var queueHistory = func(c appengine.Context, rec historyRecord) { saveHistoryLater.Call(c, rec) }
+
+// growth returns how many members the group gained in the given number of
+// days, since the oldest record within them, or nil if there are none.
+func growth(c appengine.Context, group *Group, days int) (*int, error) {
+	var records []historyRecord
+	since := now().Add(-time.Duration(days) * 24 * time.Hour)
+	q := datastore.NewQuery(historyKind).
+		Ancestor(groupKey(c, group.ID)).
+		Filter("FetchedAt >=", since).
+		Order("FetchedAt").
+		Limit(1)
+	if _, err := q.GetAll(c, &records); err != nil || len(records) == 0 {
+		return nil, err
+	}
+	n := group.Members - records[0].Members
+	return &n, nil
+}
+
+// getHistory replies with the recorded member counts of the group in a path
+// like /api/groups/golangsf/history, for the number of days given in the days
+// parameter, 30 by default.
+func getHistory(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/groups/")
+	if !strings.HasSuffix(path, "/history") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id := strings.TrimSuffix(path, "/history")
+	if !validID(id) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid id %q", id))
+		return
+	}
+
+	days := 30
+	if v := r.FormValue("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHistoryDays {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", maxHistoryDays))
+			return
+		}
+		days = n
+	}
+
+	records := []historyRecord{}
+	since := now().Add(-time.Duration(days) * 24 * time.Hour)
+	q := datastore.NewQuery(historyKind).
+		Ancestor(groupKey(c, id)).
+		Filter("FetchedAt >=", since).
+		Order("FetchedAt")
+	if _, err := q.GetAll(c, &records); err != nil {
+		c.Errorf("get history %v: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "datastore query failed")
+		return
+	}
+
+	writeJSON(c, w, r, struct {
+		APIVersion string          `json:"apiVersion"`
+		ID         string          `json:"id"`
+		History    []historyRecord `json:"history"`
+	}{apiVersion, id, records})
+}
@@ -0,0 +1,192 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+// The tests use group ids of their own and delete their records, the
+// datastore of the test instance is shared by all of them and every run.
+
+// deleteHistory deletes the history records of the group with the given id.
+func deleteHistory(t *testing.T, id string) {
+	c := newTestContext(t)
+	var records []historyRecord
+	keys, err := datastore.NewQuery(historyKind).Ancestor(groupKey(c, id)).GetAll(c, &records)
+	if err == nil {
+		err = datastore.DeleteMulti(c, keys)
+	}
+	if err != nil {
+		t.Errorf("delete history of %v: %v", id, err)
+	}
+}
+
+// putHistory saves a history record of the group with the given id.
+func putHistory(t *testing.T, id string, members int, fetched time.Time) {
+	c := newTestContext(t)
+	key := datastore.NewIncompleteKey(c, historyKind, groupKey(c, id))
+	if _, err := datastore.Put(c, key, &historyRecord{id, members, fetched}); err != nil {
+		t.Fatalf("put history: %v", err)
+	}
+}
+
+// getHistoryRecords returns the history records of the group with the given
+// id from /api/groups/{id}/history with the given query.
+func getHistoryRecords(t *testing.T, id, query string) []historyRecord {
+	w := get(t, getHistory, "/api/groups/"+id+"/history"+query)
+	if w.Code != http.StatusOK {
+		t.Fatalf("get history of %v: got status %d, want %d", id, w.Code, http.StatusOK)
+	}
+	var res struct {
+		ID      string          `json:"id"`
+		History []historyRecord `json:"history"`
+	}
+	decode(t, w, &res)
+	if res.ID != id || res.History == nil {
+		t.Errorf("get history of %v: got %s", id, w.Body)
+	}
+	return res.History
+}
+
+func TestSaveHistory(t *testing.T) {
+	fetched := time.Now().UTC().Truncate(time.Second)
+	_, cache, restore := setup(
+		&Group{ID: "history-on", Members: 100, Fetched: fetched},
+		&Group{ID: "history-off", Members: 50, Fetched: fetched},
+	)
+	defer restore()
+	defer deleteHistory(t, "history-on")
+	defer deleteHistory(t, "history-off")
+	defer func(old bool) { recordHistory = old }(recordHistory)
+	var queued []historyRecord
+	defer func(old func(appengine.Context, historyRecord)) { queueHistory = old }(queueHistory)
+	queueHistory = func(c appengine.Context, rec historyRecord) {
+		queued = append(queued, rec)
+		saveHistoryLater.Call(c, rec)
+	}
+	c := newTestContext(t)
+
+	// the fetched groups are recorded in a task
+	recordHistory = true
+	if _, _, err := load(c, cache, fetcher, "history-on"); err != nil {
+		t.Fatal(err)
+	}
+	recordHistory = false
+	if _, _, err := load(c, cache, fetcher, "history-off"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []historyRecord{{"history-on", 100, fetched}}; !reflect.DeepEqual(queued, want) {
+		t.Errorf("got records %+v queued, want %+v", queued, want)
+	}
+	got := getHistoryRecords(t, "history-on", "")
+	if len(got) != 1 || got[0].ID != "history-on" || got[0].Members != 100 || !got[0].FetchedAt.Equal(fetched) {
+		t.Errorf("got history %+v, want 100 members at %v", got, fetched)
+	}
+	if got := getHistoryRecords(t, "history-off", ""); len(got) != 0 {
+		t.Errorf("got history %+v with the recording disabled, want none", got)
+	}
+
+	// and the cached ones are not recorded again
+	recordHistory = true
+	if _, _, err := load(c, cache, fetcher, "history-on"); err != nil {
+		t.Fatal(err)
+	}
+	if got := getHistoryRecords(t, "history-on", ""); len(got) != 1 {
+		t.Errorf("got history %+v after a cache hit, want a single record", got)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	day := 24 * time.Hour
+	start := time.Now().UTC().Truncate(time.Second)
+	defer deleteHistory(t, "history-days")
+	putHistory(t, "history-days", 80, start.Add(-40*day))
+	putHistory(t, "history-days", 100, start.Add(-2*day))
+	putHistory(t, "history-days", 90, start.Add(-10*day))
+
+	tests := []struct {
+		query   string
+		members []int
+	}{
+		{"", []int{90, 100}},
+		{"?days=5", []int{100}},
+		{"?days=60", []int{80, 90, 100}},
+	}
+	for _, tt := range tests {
+		got := getHistoryRecords(t, "history-days", tt.query)
+		var members []int
+		for _, rec := range got {
+			members = append(members, rec.Members)
+		}
+		if !reflect.DeepEqual(members, tt.members) {
+			t.Errorf("%q: got history %+v, want the member counts %v", tt.query, got, tt.members)
+		}
+	}
+
+	for _, tt := range []struct {
+		url    string
+		status int
+	}{
+		{"/api/groups/history-days/history?days=0", http.StatusBadRequest},
+		{"/api/groups/history-days/history?days=366", http.StatusBadRequest},
+		{"/api/groups/history-days/history?days=many", http.StatusBadRequest},
+		{"/api/groups/bad.id/history", http.StatusBadRequest},
+		{"/api/groups/history-days", http.StatusNotFound},
+	} {
+		if w := get(t, getHistory, tt.url); w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.url, w.Code, tt.status)
+		}
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	_, _, restore := setup(
+		&Group{ID: "growth-test", Name: "GoSF", Members: 100},
+		&Group{ID: "growth-none", Name: "Golang Paris", Members: 50},
+	)
+	defer restore()
+	defer deleteHistory(t, "growth-test")
+	day := 24 * time.Hour
+	start := time.Now().UTC()
+	putHistory(t, "growth-test", 50, start.Add(-40*day))
+	putHistory(t, "growth-test", 70, start.Add(-20*day))
+	putHistory(t, "growth-test", 90, start.Add(-5*day))
+
+	tests := []struct {
+		url    string
+		growth *int
+	}{
+		{"/api/group/growth-test?growth=1", intPtr(30)},
+		{"/api/group/growth-test", nil},
+		{"/api/group/growth-none?growth=1", nil},
+	}
+	for _, tt := range tests {
+		w := get(t, getGroup, tt.url)
+		var g struct{ GrowthLast30d *int }
+		decode(t, w, &g)
+		if !reflect.DeepEqual(g.GrowthLast30d, tt.growth) {
+			t.Errorf("%s: got %s, want the growth %v", tt.url, w.Body, intString(tt.growth))
+		}
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+// intString returns *n as a string, or null.
+func intString(n *int) string {
+	if n == nil {
+		return "null"
+	}
+	return strconv.Itoa(*n)
+}
@@ -0,0 +1,116 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	if v := os.Getenv("FIELD_NAMES"); v != "" {
+		if names, err := parseFieldNames(v); err == nil {
+			for field, list := range names {
+				fieldNames[field] = list
+			}
+		}
+	}
+}
+
+// fieldNames maps the Group fields decoded by fetch to the names meetup has
+// used for them across API versions, the first one present wins. Names with
+// dots refer to fields of nested objects, like "group_photo.thumb_link".
+//
+// They can be overridden with FIELD_NAMES, as in
+// "Members=members|member_count,URL=link".
+var fieldNames = map[string][]string{
+	"Name":          {"name"},
+	"URL":           {"link"},
+	"PhotoURL":      {"group_photo.highres_link", "group_photo.thumb_link"},
+	"Organizer":     {"organizer.name"},
+	"NextEventName": {"next_event.name"},
+	"NextEventTime": {"next_event.time"},
+	"City":          {"city"},
+	"Country":       {"country"},
+	"Members":       {"members", "member_count"},
+	"Lat":           {"lat"},
+	"Lon":           {"lon"},
+}
+
+// parseFieldNames parses a list of field names in the FIELD_NAMES format.
+func parseFieldNames(v string) (map[string][]string, error) {
+	names := make(map[string][]string)
+	for _, entry := range splitIDs(v) {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid field names %q", entry)
+		}
+		field := strings.TrimSpace(entry[:i])
+		if _, ok := fieldNames[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		var list []string
+		for _, name := range strings.Split(entry[i+1:], "|") {
+			if name = strings.TrimSpace(name); name != "" {
+				list = append(list, name)
+			}
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("no names for field %q", field)
+		}
+		names[field] = list
+	}
+	return names, nil
+}
+
+// decodeField decodes into v the value of the given Group field in the
+// object m, leaving v unchanged when none of its names are present.
+func decodeField(m map[string]json.RawMessage, field string, v interface{}) error {
+	for _, name := range fieldNames[field] {
+		if raw, ok := lookupField(m, name); ok {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// lookupField returns the value of the field with the given name in the
+// object m, following the dots into nested objects. Null and empty string
+// values count as missing.
+func lookupField(m map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		raw, ok := m[part]
+		if !ok || string(raw) == "null" || string(raw) == `""` {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return raw, true
+		}
+		m = nil
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// hasFields reports whether the object m has any of the names in fieldNames,
+// so it's likely to be a group.
+func hasFields(m map[string]json.RawMessage) bool {
+	for _, names := range fieldNames {
+		for _, name := range names {
+			if _, ok := lookupField(m, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
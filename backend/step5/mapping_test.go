@@ -0,0 +1,70 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFieldNames(t *testing.T) {
+	defer setKeys("test-key")()
+	tests := []struct {
+		name    string
+		body    string
+		members int
+		city    string
+	}{
+		{"old names", `{"name": "GoSF", "members": 100, "city": "San Francisco"}`, 100, "San Francisco"},
+		{"new names", `{"name": "GoSF", "member_count": 200, "location": {"city": "Oakland"}}`, 200, "Oakland"},
+		{"both", `{"name": "GoSF", "members": 100, "member_count": 200, "city": "San Francisco", "location": {"city": "Oakland"}}`, 100, "San Francisco"},
+		{"null old names", `{"name": "GoSF", "members": null, "member_count": 200, "city": "", "location": {"city": "Oakland"}}`, 200, "Oakland"},
+		{"none", `{"name": "GoSF"}`, 0, ""},
+	}
+	defer func(old []string) { fieldNames["City"] = old }(fieldNames["City"])
+	names, err := parseFieldNames("City=city|location.city")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fieldNames["City"] = names["City"]
+	for _, tt := range tests {
+		stop := meetupServer(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		})
+		g, err := fetch(newTestContext(t), "golangsf", time.Time{})
+		stop()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if g.Name != "GoSF" || g.Members != tt.members || g.City != tt.city {
+			t.Errorf("%s: got %q in %q with %d members, want %q in %q with %d", tt.name, g.Name, g.City, g.Members, "GoSF", tt.city, tt.members)
+		}
+	}
+}
+
+func TestParseFieldNames(t *testing.T) {
+	tests := []struct {
+		v    string
+		want map[string][]string
+		ok   bool
+	}{
+		{"Members=member_count", map[string][]string{"Members": {"member_count"}}, true},
+		{" Members = members | member_count ,URL=link", map[string][]string{"Members": {"members", "member_count"}, "URL": {"link"}}, true},
+		{"Members", nil, false},
+		{"Members=", nil, false},
+		{"Members=|", nil, false},
+		{"Founded=created", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseFieldNames(tt.v)
+		if (err == nil) != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFieldNames(%q) = %q, %v; want %q and ok %v", tt.v, got, err, tt.want, tt.ok)
+		}
+	}
+}
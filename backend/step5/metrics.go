@@ -0,0 +1,93 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"appengine"
+)
+
+func init() {
+	handle("/metrics", getMetrics)
+}
+
+// counters holds the process wide counters reported by /metrics, they must
+// be accessed atomically since groups are loaded concurrently.
+type counters struct {
+	CacheHits      int64
+	CacheMisses    int64
+	FetchSuccesses int64
+	FetchFailures  int64
+	Timeouts       int64
+	RateLimited    int64
+	FetchNanos     int64
+}
+
+var metrics counters
+
+// fetchGroup fetches the group with the given id using f, recording the
+// outcome and latency in metrics. If last is the previously fetched copy of
+// the group and f supports it, the group is fetched only if it was modified.
+func fetchGroup(c appengine.Context, f Fetcher, id string, last *Group) (*Group, error) {
+	start := now()
+	var group *Group
+	var err error
+	if cf, ok := f.(conditionalFetcher); ok && last != nil {
+		group, err = cf.FetchIfModified(c, id, last.Fetched)
+	} else {
+		group, err = f.Fetch(c, id)
+	}
+	atomic.AddInt64(&metrics.FetchNanos, int64(now().Sub(start)))
+	if err != nil && err != errNotModified {
+		atomic.AddInt64(&metrics.FetchFailures, 1)
+	} else {
+		atomic.AddInt64(&metrics.FetchSuccesses, 1)
+	}
+	return group, err
+}
+
+// fetchGroupMembers fetches the member count of the group with the given id
+// using f, recording the outcome and latency in metrics.
+func fetchGroupMembers(c appengine.Context, f memberFetcher, id string) (int, error) {
+	start := now()
+	members, err := f.FetchMembers(c, id)
+	atomic.AddInt64(&metrics.FetchNanos, int64(now().Sub(start)))
+	if err != nil {
+		atomic.AddInt64(&metrics.FetchFailures, 1)
+	} else {
+		atomic.AddInt64(&metrics.FetchSuccesses, 1)
+	}
+	return members, err
+}
+
+// getMetrics replies with the current value of the counters as JSON.
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	c := newContext(w, r)
+
+	var res struct {
+		CacheHits      int64   `json:"cacheHits"`
+		CacheMisses    int64   `json:"cacheMisses"`
+		FetchSuccesses int64   `json:"fetchSuccesses"`
+		FetchFailures  int64   `json:"fetchFailures"`
+		Timeouts       int64   `json:"timeouts"`
+		RateLimited    int64   `json:"rateLimited"`
+		AvgFetchMillis float64 `json:"avgFetchMillis"`
+	}
+	res.CacheHits = atomic.LoadInt64(&metrics.CacheHits)
+	res.CacheMisses = atomic.LoadInt64(&metrics.CacheMisses)
+	res.FetchSuccesses = atomic.LoadInt64(&metrics.FetchSuccesses)
+	res.FetchFailures = atomic.LoadInt64(&metrics.FetchFailures)
+	res.Timeouts = atomic.LoadInt64(&metrics.Timeouts)
+	res.RateLimited = atomic.LoadInt64(&metrics.RateLimited)
+	if n := res.FetchSuccesses + res.FetchFailures; n > 0 {
+		nanos := atomic.LoadInt64(&metrics.FetchNanos)
+		res.AvgFetchMillis = float64(nanos) / float64(n) / float64(time.Millisecond)
+	}
+
+	writeJSON(c, w, r, res)
+}
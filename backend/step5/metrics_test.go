@@ -0,0 +1,36 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	f.fail("golang-paris", &kindError{ErrNetwork, errors.New("boom")})
+	metrics = counters{}
+	c := newTestContext(t)
+
+	// two fetches succeed and one fails, then the two are cached
+	for i := 0; i < 2; i++ {
+		for _, id := range ids {
+			load(c, cache, f, id)
+		}
+	}
+
+	var res struct {
+		CacheHits      int64
+		CacheMisses    int64
+		FetchSuccesses int64
+		FetchFailures  int64
+	}
+	decode(t, get(t, getMetrics, "/metrics"), &res)
+	if res.CacheHits != 2 || res.CacheMisses != 4 || res.FetchSuccesses != 2 || res.FetchFailures != 2 {
+		t.Errorf("got %+v, want 2 hits, 4 misses, 2 successes and 2 failures", res)
+	}
+}
@@ -0,0 +1,82 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	if t := os.Getenv("MEETUP_PATH_TEMPLATE"); t != "" {
+		pathTemplate = t
+	}
+	for _, entry := range splitIDs(os.Getenv("GROUP_PATH_TEMPLATES")) {
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			panic(fmt.Sprintf("invalid GROUP_PATH_TEMPLATES entry %q, want id=template", entry))
+		}
+		groupPathTemplates[strings.TrimSpace(entry[:i])] = strings.TrimSpace(entry[i+1:])
+	}
+
+	// a bad template would break every fetch, better not to start at all
+	if err := checkPathTemplate(pathTemplate); err != nil {
+		panic(err)
+	}
+	for _, t := range groupPathTemplates {
+		if err := checkPathTemplate(t); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// pathTemplate is the path of a group in the meetup API, relative to
+// apiBaseURL, with a %s where its id goes. It can be overridden with
+// MEETUP_PATH_TEMPLATE.
+var pathTemplate = "%s"
+
+// groupPathTemplates override pathTemplate for some groups, they're read from
+// GROUP_PATH_TEMPLATES as in "golangsf=2/groups?group_urlname=%s".
+var groupPathTemplates = make(map[string]string)
+
+// checkPathTemplate returns an error unless t has a single %s and no other
+// formatting verbs.
+func checkPathTemplate(t string) error {
+	if strings.Count(t, "%s") != 1 || strings.Count(t, "%") != 1 {
+		return fmt.Errorf("invalid path template %q, it needs a single %%s for the id", t)
+	}
+	return nil
+}
+
+// groupPath returns the path of the group with the given id in the meetup
+// API, which may include query parameters.
+func groupPath(id string) string {
+	t := pathTemplate
+	if gt, ok := groupPathTemplates[id]; ok {
+		t = gt
+	}
+	return fmt.Sprintf(t, id)
+}
+
+// unwrapResults returns the group in a response of the legacy groups API, as
+// in {"results": [{"name": ...}], "meta": {...}}, or m itself for the other
+// APIs, which reply with the group alone. No results means there's no such
+// group.
+func unwrapResults(m map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	raw, ok := m["results"]
+	if !ok {
+		return m, nil
+	}
+	var results []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &results); err != nil {
+		return nil, fmt.Errorf("results: %v", err)
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+	return results[0], nil
+}
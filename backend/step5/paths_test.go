@@ -0,0 +1,82 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckPathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		ok       bool
+	}{
+		{"%s", true},
+		{"2/groups?group_urlname=%s", true},
+		{"groups/%s/details", true},
+		{"groups", false},
+		{"%s/%s", false},
+		{"%s?page=%d", false},
+		{"%d", false},
+		{"100%%/%s", false},
+	}
+	for _, tt := range tests {
+		if err := checkPathTemplate(tt.template); (err == nil) != tt.ok {
+			t.Errorf("checkPathTemplate(%q) = %v, want ok %v", tt.template, err, tt.ok)
+		}
+	}
+}
+
+func TestGroupPathTemplates(t *testing.T) {
+	defer setKeys("test-key")()
+	defer func(old string) { pathTemplate = old }(pathTemplate)
+	pathTemplate = "groups/%s"
+	groupPathTemplates["golangsf"] = "2/groups?group_urlname=%s"
+	defer delete(groupPathTemplates, "golangsf")
+
+	var got []string
+	defer meetupServer(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.Path+"?group_urlname="+r.FormValue("group_urlname"))
+		switch {
+		case r.URL.Path == "/2/groups" && r.FormValue("group_urlname") == "golangsf":
+			fmt.Fprint(w, `{"results": [{"name": "GoSF", "members": 100}], "meta": {"count": 1}}`)
+		case r.URL.Path == "/2/groups":
+			fmt.Fprint(w, `{"results": [], "meta": {"count": 0}}`)
+		case r.URL.Path == "/groups/golang-paris":
+			fmt.Fprint(w, `{"name": "Golang Paris", "members": 50}`)
+		default:
+			fmt.Fprint(w, `{"meta": {"count": 1}}`)
+		}
+	})()
+
+	// the legacy API lists the group in its results
+	c := newTestContext(t)
+	g, err := fetch(c, "golangsf", time.Time{})
+	if err != nil || g.Name != "GoSF" || g.Members != 100 {
+		t.Errorf("fetch with the legacy template: got %+v, %v; want GoSF with 100 members", g, err)
+	}
+	g, err = fetch(c, "golang-paris", time.Time{})
+	if err != nil || g.Name != "Golang Paris" || g.Members != 50 {
+		t.Errorf("fetch with the default template: got %+v, %v; want Golang Paris with 50 members", g, err)
+	}
+	if want := []string{"/2/groups?group_urlname=golangsf", "/groups/golang-paris?group_urlname="}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+
+	// no results means no group, and no known fields an unexpected response
+	groupPathTemplates["golang-users-berlin"] = "2/groups?group_urlname=%s"
+	defer delete(groupPathTemplates, "golang-users-berlin")
+	if _, err := fetch(c, "golang-users-berlin", time.Time{}); err != ErrNotFound {
+		t.Errorf("fetch with no results: got %v, want %v", err, ErrNotFound)
+	}
+	_, err = fetch(c, "golang-amsterdam", time.Time{})
+	if want := `decode golang-amsterdam: no group fields (body: "{\"meta\": {\"count\": 1}}")`; err == nil || err.Error() != want || Kind(err) != ErrMeetupAPI {
+		t.Errorf("fetch with no group fields: got %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,172 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"appengine"
+)
+
+// newContext returns the appengine.Context for the request, which prefixes
+// every log line with the id given in the X-Request-ID header, or a new one if
+// there's none or it's not valid. The id is also echoed in the response
+// headers, along with the apiVersion. The request is expected to be done
+// within requestTimeout, see requestDeadline.
+func newContext(w http.ResponseWriter, r *http.Request) appengine.Context {
+	id := r.Header.Get("X-Request-ID")
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", id)
+	w.Header().Set("X-API-Version", apiVersion)
+	return requestContext{appengine.NewContext(r), id, now().Add(requestTimeout)}
+}
+
+// maxRequestIDLen is the maximum length of the request ids we accept.
+const maxRequestIDLen = 64
+
+// requestIDRE matches the request ids we accept, anything else could be used
+// to forge log lines or headers.
+var requestIDRE = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validRequestID reports whether id can be used as a request id.
+func validRequestID(id string) bool {
+	return len(id) <= maxRequestIDLen && requestIDRE.MatchString(id)
+}
+
+// newRequestID returns a random request id.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+// jsonLogs makes the logs JSON objects, for log analysis tools, instead of
+// plain text. Set JSON_LOGS=1 to enable it.
+var jsonLogs = os.Getenv("JSON_LOGS") == "1"
+
+// requestContext is an appengine.Context adding the request id to the logs.
+type requestContext struct {
+	appengine.Context
+	id       string
+	deadline time.Time
+}
+
+// requestDeadline returns the time by which the request of c should be done,
+// or requestTimeout from now for the contexts not made by newContext, as the
+// ones of the tasks.
+func requestDeadline(c appengine.Context) time.Time {
+	if rc, ok := c.(requestContext); ok {
+		return rc.deadline
+	}
+	return now().Add(requestTimeout)
+}
+
+func (c requestContext) Debugf(format string, args ...interface{}) {
+	c.log("debug", fmt.Sprintf(format, args...), nil)
+}
+
+func (c requestContext) Infof(format string, args ...interface{}) {
+	c.log("info", fmt.Sprintf(format, args...), nil)
+}
+
+func (c requestContext) Warningf(format string, args ...interface{}) {
+	c.log("warning", fmt.Sprintf(format, args...), nil)
+}
+
+func (c requestContext) Errorf(format string, args ...interface{}) {
+	c.log("error", fmt.Sprintf(format, args...), nil)
+}
+
+func (c requestContext) Criticalf(format string, args ...interface{}) {
+	c.log("critical", fmt.Sprintf(format, args...), nil)
+}
+
+// log logs msg with the given fields at the given level, as a JSON object if
+// jsonLogs is set, or as text with the fields as key=value pairs otherwise.
+func (c requestContext) log(level, msg string, fields []logField) {
+	var line string
+	if jsonLogs {
+		line = jsonLogLine(level, c.id, msg, fields)
+	} else {
+		line = fmt.Sprintf("[%s] %s", c.id, msg)
+		for _, f := range fields {
+			line += fmt.Sprintf(" %s=%v", f.key, f.value)
+		}
+	}
+
+	switch level {
+	case "debug":
+		c.Context.Debugf("%s", line)
+	case "info":
+		c.Context.Infof("%s", line)
+	case "warning":
+		c.Context.Warningf("%s", line)
+	case "error":
+		c.Context.Errorf("%s", line)
+	default:
+		c.Context.Criticalf("%s", line)
+	}
+}
+
+// logField is a named value added to a log line.
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// jsonLogLine returns the log line as a JSON object.
+func jsonLogLine(level, requestID, msg string, fields []logField) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	write := func(key string, value interface{}) {
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	write("level", level)
+	write("requestId", requestID)
+	write("msg", msg)
+	for _, f := range fields {
+		write(f.key, f.value)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// logInfo logs msg at the info level with the given fields, given as key and
+// value pairs, which become separate fields of the JSON logs.
+func logInfo(c appengine.Context, msg string, keyValues ...interface{}) {
+	var fields []logField
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		fields = append(fields, logField{fmt.Sprint(keyValues[i]), keyValues[i+1]})
+	}
+	if rc, ok := c.(requestContext); ok {
+		rc.log("info", msg, fields)
+		return
+	}
+	for _, f := range fields {
+		msg += fmt.Sprintf(" %s=%v", f.key, f.value)
+	}
+	c.Infof("%s", msg)
+}
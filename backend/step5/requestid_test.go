@@ -0,0 +1,88 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"abc-123", true},
+		{"req_1.2-X", true},
+		{strings.Repeat("a", maxRequestIDLen), true},
+		{strings.Repeat("a", maxRequestIDLen+1), false},
+		{"", false},
+		{"evil\nline", false},
+		{"a b", false},
+		{`"quoted"`, false},
+	}
+	for _, tt := range tests {
+		_, _, restore := setup(testGroups()...)
+		r := newRequest(t, "GET", "/api/group/golangsf", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Request-ID", tt.header)
+		}
+		w := serve(getGroup, r)
+		restore()
+
+		id := w.Header().Get("X-Request-ID")
+		if tt.keep {
+			if id != tt.header {
+				t.Errorf("X-Request-ID %q: got %q back", tt.header, id)
+			}
+			continue
+		}
+		if id == tt.header || !validRequestID(id) {
+			t.Errorf("X-Request-ID %q: got %q back, want a new valid id", tt.header, id)
+		}
+	}
+}
+
+func TestJSONLogs(t *testing.T) {
+	defer func(old bool) { jsonLogs = old }(jsonLogs)
+	lc := newLogContext(newTestContext(t))
+	c := requestContext{lc, "req-1", time.Now()}
+
+	jsonLogs = true
+	c.Errorf("fetch %v: %v", "golangsf", `"boom"`)
+	logInfo(c, "get groups", "groups", 2, "ms", 412)
+	jsonLogs = false
+	c.Errorf("fetch %v: %v", "golangsf", `"boom"`)
+	logInfo(c, "get groups", "groups", 2, "ms", 412)
+
+	lines := lc.lines()
+	if len(lines) != 4 {
+		t.Fatalf("got logs %q, want 4 lines", lines)
+	}
+	for i, want := range []map[string]interface{}{
+		{"level": "error", "requestId": "req-1", "msg": `fetch golangsf: "boom"`},
+		{"level": "info", "requestId": "req-1", "msg": "get groups", "groups": 2.0, "ms": 412.0},
+	} {
+		line := lines[i][strings.Index(lines[i], " ")+1:]
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Errorf("decode log line %q: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got log line %s, want %v", line, want)
+		}
+	}
+	want := []string{
+		`error: [req-1] fetch golangsf: "boom"`,
+		"info: [req-1] get groups groups=2 ms=412",
+	}
+	if got := lines[2:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got text logs %q, want %q", got, want)
+	}
+}
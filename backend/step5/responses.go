@@ -0,0 +1,116 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+func init() {
+	responseTTL = durationEnv("RESPONSE_CACHE_TTL", responseTTL)
+}
+
+// responseTTL is how long the encoded responses of getGroups are kept in
+// memory, so identical requests in a burst don't decode, sort and encode the
+// groups again. It can be overridden with RESPONSE_CACHE_TTL, zero disables
+// it.
+var responseTTL = 5 * time.Second
+
+// maxResponses is the maximum number of responses kept in memory.
+const maxResponses = 100
+
+// responseHeaders are the headers kept with the cached responses, the rest
+// are either set again for every request or depend on it.
+var responseHeaders = []string{
+	"Content-Type",
+	"X-Cache-Hits",
+	"X-Cache-Misses",
+	"X-Fetch-Errors",
+	"X-Missing-Coordinates",
+}
+
+// cachedResponse is an encoded response of getGroups.
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+var responses = struct {
+	sync.Mutex
+	m map[string]*cachedResponse
+}{m: make(map[string]*cachedResponse)}
+
+// responseKey returns the key of the response to the given request in the
+// response cache, or "" if it can't be cached.
+func responseKey(r *http.Request) string {
+	if responseTTL <= 0 || r.Method != "GET" || r.FormValue("stream") == "1" {
+		return ""
+	}
+	// Encode sorts the parameters so their order doesn't matter, the format
+	// is added since it can come from the Accept header
+	return r.URL.Query().Encode() + "|" + responseFormat(r)
+}
+
+// loadResponse returns the cached response with the given key, if it has not
+// expired yet.
+func loadResponse(key string) (*cachedResponse, bool) {
+	if key == "" {
+		return nil, false
+	}
+	responses.Lock()
+	defer responses.Unlock()
+	res, ok := responses.m[key]
+	if !ok || now().After(res.expires) {
+		return nil, false
+	}
+	return res, true
+}
+
+// storeResponse caches the response body with the given key, along with the
+// responseHeaders already set in header.
+func storeResponse(key string, header http.Header, body []byte) {
+	if key == "" {
+		return
+	}
+	res := &cachedResponse{
+		header:  http.Header{},
+		body:    body,
+		expires: now().Add(responseTTL),
+	}
+	for _, h := range responseHeaders {
+		if v, ok := header[h]; ok {
+			res.header[h] = v
+		}
+	}
+
+	responses.Lock()
+	defer responses.Unlock()
+	if len(responses.m) >= maxResponses {
+		// make room removing the expired responses, or any if there are
+		// none
+		for k, r := range responses.m {
+			if now().After(r.expires) {
+				delete(responses.m, k)
+			}
+		}
+		for k := range responses.m {
+			if len(responses.m) < maxResponses {
+				break
+			}
+			delete(responses.m, k)
+		}
+	}
+	responses.m[key] = res
+}
+
+// flushResponses removes all the cached responses.
+func flushResponses() {
+	responses.Lock()
+	responses.m = make(map[string]*cachedResponse)
+	responses.Unlock()
+}
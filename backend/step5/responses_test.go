@@ -0,0 +1,87 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseCache(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	responseTTL = time.Minute
+	start := time.Now()
+	clock := start
+	defer func(old func() time.Time) { now = old }(now)
+	now = func() time.Time { return clock }
+
+	first := get(t, getGroups, "/api/groups?sort=members&country=us")
+
+	// the groups change, but the response is reused whatever the order of
+	// the parameters
+	f.groups["golangsf"].Members = 200
+	deleteKeys(cache, []string{"golangsf", allGroupsKey(ids)})
+	second := get(t, getGroups, "/api/groups?country=us&sort=members")
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Errorf("got different responses:\n%s\n%s", first.Body, second.Body)
+	}
+	if first.Header().Get("ETag") != second.Header().Get("ETag") {
+		t.Errorf("got ETags %q and %q, want the same", first.Header().Get("ETag"), second.Header().Get("ETag"))
+	}
+	if n := f.fetches("golangsf"); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+
+	// other requests, and identical ones once it expires, are not
+	if other := get(t, getGroups, "/api/groups?country=us"); bytes.Equal(first.Body.Bytes(), other.Body.Bytes()) {
+		t.Error("got the same response for other parameters")
+	}
+	clock = clock.Add(2 * responseTTL)
+	if third := get(t, getGroups, "/api/groups?sort=members&country=us"); bytes.Equal(first.Body.Bytes(), third.Body.Bytes()) {
+		t.Error("got the same response after it expired")
+	}
+}
+
+func TestResponseCacheBounded(t *testing.T) {
+	defer flushResponses()
+	for i := 0; i < 2*maxResponses; i++ {
+		storeResponse(fmt.Sprint(i), nil, []byte("{}"))
+	}
+	responses.Lock()
+	n := len(responses.m)
+	responses.Unlock()
+	if n > maxResponses {
+		t.Errorf("got %d cached responses, want at most %d", n, maxResponses)
+	}
+}
+
+func BenchmarkResponseCache(b *testing.B) {
+	var groups []*Group
+	for i := 0; i < 200; i++ {
+		groups = append(groups, &Group{ID: fmt.Sprintf("group-%d", i), Name: fmt.Sprintf("Group %d", i), Members: i})
+	}
+	_, _, restore := setup(groups...)
+	defer restore()
+
+	for _, bb := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"cached", time.Minute},
+		{"uncached", 0},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			responseTTL = bb.ttl
+			r := newRequest(b, "GET", "/api/groups?sort=-members", nil)
+			for i := 0; i < b.N; i++ {
+				getGroups(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
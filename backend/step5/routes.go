@@ -0,0 +1,42 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"strings"
+)
+
+// route is an endpoint registered with handle.
+type route struct {
+	path string
+	h    http.HandlerFunc
+}
+
+// routes are the endpoints registered with handle, in order.
+var routes []route
+
+// handle registers h for the given path in http.DefaultServeMux, and keeps it
+// for RegisterHandlers.
+func handle(path string, h http.HandlerFunc) {
+	routes = append(routes, route{path, h})
+	http.HandleFunc(path, h)
+}
+
+// RegisterHandlers registers every endpoint in mux under the given prefix, as
+// in /v1/api/groups for the prefix "/v1". The handlers see the request paths
+// without the prefix. They're always registered in http.DefaultServeMux
+// without a prefix too, so mux must be a different one unless the prefix is
+// not empty.
+func RegisterHandlers(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	for _, rt := range routes {
+		var h http.Handler = rt.h
+		if prefix != "" {
+			h = http.StripPrefix(prefix, h)
+		}
+		mux.Handle(prefix+rt.path, h)
+	}
+}
@@ -0,0 +1,57 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterHandlers(t *testing.T) {
+	_, _, restore := setup(testGroups()...)
+	defer restore()
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, "/v1/")
+
+	serveMux := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newRequest(t, "GET", url, nil))
+		return w
+	}
+
+	// every endpoint is under the prefix
+	for _, rt := range routes {
+		r := newRequest(t, "GET", "/v1"+rt.path, nil)
+		if _, pattern := mux.Handler(r); pattern != "/v1"+rt.path {
+			t.Errorf("%s: got pattern %q, want %q", r.URL.Path, pattern, "/v1"+rt.path)
+		}
+	}
+
+	var res groupsResponse
+	w := serveMux("/v1/api/groups")
+	if w.Code != http.StatusOK {
+		t.Fatalf("/v1/api/groups: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	decode(t, w, &res)
+	if len(res.Groups) != 3 {
+		t.Errorf("/v1/api/groups: got groups %+v, want 3", res.Groups)
+	}
+
+	// the handlers see the paths without the prefix
+	var g Group
+	w = serveMux("/v1/api/group/golangsf")
+	if w.Code != http.StatusOK {
+		t.Fatalf("/v1/api/group/golangsf: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	decode(t, w, &g)
+	if g.ID != "golangsf" {
+		t.Errorf("/v1/api/group/golangsf: got group %q, want golangsf", g.ID)
+	}
+
+	if w := serveMux("/api/groups"); w.Code != http.StatusNotFound {
+		t.Errorf("/api/groups without the prefix: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,89 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"appengine"
+)
+
+func init() {
+	// App Engine sends it to the instances it's about to stop, so like the
+	// warmup requests it's left out of RegisterHandlers
+	http.HandleFunc("/_ah/stop", stopInstance)
+}
+
+// background counts the goroutines started with inBackground. It's not a
+// sync.WaitGroup since Shutdown can give up waiting while goroutines are still
+// being started, which a WaitGroup doesn't allow. idle is closed once there
+// are none left.
+var background struct {
+	sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+// inBackground runs fn in a new goroutine, which Shutdown waits for.
+func inBackground(fn func()) {
+	background.Lock()
+	if background.n == 0 {
+		background.idle = make(chan struct{})
+	}
+	background.n++
+	background.Unlock()
+
+	go func() {
+		defer func() {
+			background.Lock()
+			background.n--
+			if background.n == 0 {
+				close(background.idle)
+			}
+			background.Unlock()
+		}()
+		fn()
+	}()
+}
+
+// Shutdown waits for the goroutines started with inBackground, as the fetches
+// still caching groups after their request is done, so they're not cut off
+// while updating memcache. It returns ctx.Err() if ctx is done before they
+// finish.
+func Shutdown(ctx context.Context) error {
+	background.Lock()
+	if background.n == 0 {
+		background.Unlock()
+		return nil
+	}
+	idle := background.idle
+	background.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdownTimeout is how long an instance being stopped waits for its
+// background work, App Engine gives it 30 seconds.
+var shutdownTimeout = 25 * time.Second
+
+// stopInstance waits for the background work of the instance before App
+// Engine stops it.
+func stopInstance(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := Shutdown(ctx); err != nil {
+		c.Warningf("shutdown: %v, stopping with background work in progress", err)
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,55 @@
+//  Copyright 2011 The Go Authors.  All rights reserved.
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	f, cache, restore := setup(testGroups()...)
+	defer restore()
+	f.wait = make(chan struct{})
+	c := newTestContext(t)
+	inBackground(func() { refresh(c, cache, f, "golangsf", nil) })
+
+	// the refresh is still blocked when the deadline passes
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err := Shutdown(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("with the refresh blocked: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// and Shutdown waits for a slow one
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(f.wait)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := Shutdown(ctx); err != nil {
+		t.Fatalf("with a slow refresh: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Shutdown returned after %v, before the refresh was done", elapsed)
+	}
+	var cg cachedGroup
+	if err := cache.Get("golangsf", &cg); err != nil || cg.Group == nil {
+		t.Errorf("the refresh didn't cache the group before Shutdown returned: %v", err)
+	}
+
+	// App Engine asks for it before stopping the instance
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, newRequest(t, "GET", "/_ah/stop", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/_ah/stop: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -6,6 +6,7 @@
 
 /* the response from /api/groups should look like:
     {
+        "apiVersion": "2",
         "Groups": [{
             "Name": "GoSV",
             "URL": "http://www.meetup.com/golangsv",
@@ -19,9 +20,12 @@
             "City": "San Francisco",
             "Country": "US"
         }],
-        "Errors": [
-            "something bad happened"
-        ]
+        "Errors": [{
+            "id": "golang-paris",
+            "kind": "network",
+            "message": "something bad happened"
+        }],
+        "TotalMembers": 1587
     }
 */
 function GroupsCtrl($scope, $http, $filter) {
@@ -50,7 +54,8 @@ function GroupsCtrl($scope, $http, $filter) {
     $http.get('/api/groups').then(function(res) {
         $scope.groups = res.data.Groups;
         for (var i in res.data.Errors) {
-            $scope.log(res.data.Errors[i]);
+            var e = res.data.Errors[i];
+            $scope.log(e.id + ': ' + e.message);
         }
         $scope.refilter();
     }, function(msg) {